@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"github.com/driscollco-cluster/operator-1password/internal/conf"
 	"github.com/driscollco-cluster/operator-1password/internal/crds"
 	"github.com/driscollco-cluster/operator-1password/internal/operator"
 	operatorLib "github.com/driscollco-core/kubernetes-operator"
 	"github.com/driscollco-core/service"
 	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"os"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
 func main() {
@@ -21,12 +32,88 @@ func main() {
 
 	go func() {
 		log.SetLogger(logr.Discard())
-		actualOp := operator.New(s.Log())
+		k8sConfig, err := config.GetConfig()
+		if err != nil {
+			s.Log().Error("unable to load the kubernetes config", "error", err.Error())
+			os.Exit(0)
+		}
+		k8sCache, err := operator.NewCache(context.Background(), k8sConfig)
+		if err != nil {
+			s.Log().Error("unable to create the cache", "error", err.Error())
+			os.Exit(0)
+		}
+		k8sClient, err := client.New(k8sConfig, client.Options{})
+		if err != nil {
+			s.Log().Error("unable to create the kubernetes client", "error", err.Error())
+			os.Exit(0)
+		}
+		if err = operator.WatchChildren(context.Background(), k8sCache, k8sClient, s.Log()); err != nil {
+			s.Log().Error("unable to watch child secrets", "error", err.Error())
+			os.Exit(0)
+		}
+		if err = operator.WatchNamespaces(context.Background(), k8sCache, k8sClient, s.Log()); err != nil {
+			s.Log().Error("unable to watch namespaces", "error", err.Error())
+			os.Exit(0)
+		}
+		go func() {
+			if err := k8sCache.Start(context.Background()); err != nil {
+				s.Log().Error("unable to start the cache", "error", err.Error())
+				os.Exit(0)
+			}
+		}()
+		if conf.Config.Webhook.Enabled {
+			webhookServer := webhook.NewServer(webhook.Options{Port: conf.Config.Webhook.Port, CertDir: conf.Config.Webhook.CertDir})
+			webhookServer.Register(operator.WebhookPath, operator.NewWebhook())
+			go func() {
+				if err := webhookServer.Start(context.Background()); err != nil {
+					s.Log().Error("unable to start the webhook server", "error", err.Error())
+					os.Exit(0)
+				}
+			}()
+		}
+		actualOp := operator.New(s.Log(), k8sCache)
+		go func() {
+			if err := startClusterOperator(k8sConfig, actualOp); err != nil {
+				s.Log().Error("unable to start the cluster operator", "error", err.Error())
+				os.Exit(0)
+			}
+		}()
 		op := operatorLib.New("operator-opsecrets", actualOp.Reconcile)
-		if err := op.Start("crds.driscoll.co", "v1", &crds.OpSecret{}, &crds.OpSecretList{}); err != nil {
+		if err := op.Start(crds.GroupVersion.Group, crds.GroupVersion.Version, &crds.OpSecret{}, &crds.OpSecretList{}); err != nil {
 			s.Log().Error("unable to start the operator", "error", err.Error())
 			os.Exit(0)
 		}
 	}()
 	s.Run()
 }
+
+// startClusterOperator reconciles ClusterOpSecrets. The operator library starts a manager per kind, each binding the
+// default metrics address, so ClusterOpSecrets have a manager of their own with its metrics and health probes disabled
+func startClusterOperator(k8sConfig *rest.Config, actualOp operator.Operator) error {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	scheme.AddKnownTypes(crds.GroupVersion, &crds.ClusterOpSecret{}, &crds.ClusterOpSecretList{})
+	metav1.AddToGroupVersion(scheme, crds.GroupVersion)
+
+	mgr, err := ctrl.NewManager(k8sConfig, ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+	})
+	if err != nil {
+		return err
+	}
+	recorder := mgr.GetEventRecorderFor("operator-clusteropsecrets")
+	err = ctrl.NewControllerManagedBy(mgr).
+		Named("clusteropsecrets").
+		For(&crds.ClusterOpSecret{}).
+		Complete(reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+			return actualOp.ReconcileCluster(ctx, req, mgr.GetClient(), recorder, mgr.GetScheme())
+		}))
+	if err != nil {
+		return err
+	}
+	return mgr.Start(context.Background())
+}
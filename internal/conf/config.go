@@ -9,9 +9,26 @@ type config struct {
 			Token string
 		}
 	}
+	Operator struct {
+		Pod struct {
+			Name      string
+			Namespace string
+		}
+	}
+	Webhook struct {
+		Enabled bool
+		Port    int
+		CertDir string
+	}
 	Secrets struct {
 		Refresh struct {
 			MinIntervalSeconds int
 		}
+		Events struct {
+			MaxCount      int
+			MaxAgeSeconds int
+		}
+		// AllowCrossNamespace lets an OpSecret create secrets in namespaces other than its own
+		AllowCrossNamespace bool
 	}
 }
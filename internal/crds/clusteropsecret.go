@@ -0,0 +1,31 @@
+package crds
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Type",type=string,JSONPath=".spec.secret.secret-type",description="The type of secret to create"
+//+kubebuilder:printcolumn:name="Vault",type=string,JSONPath=".spec.source.vault",description="The vault the secret is sourced from"
+//+kubebuilder:printcolumn:name="Item",type=string,JSONPath=".spec.source.item",description="The item the secret is sourced from"
+//+kubebuilder:printcolumn:name="Section",type=string,JSONPath=".spec.source.section",description="The item the secret is sourced from"
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=".status.phase",description="Whether the secret is in sync with 1Password"
+
+// ClusterOpSecret is the intention to create a secret from a 1Password item in any number of namespaces. It is cluster
+// scoped so that distributing secrets across namespaces can be restricted to platform admins.
+// Its fields match OpSecret's exactly, so one can be converted to the other
+type ClusterOpSecret struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	metav1.TypeMeta   `json:",inline"`
+	Spec              OpSecretSpec   `json:"spec,omitempty"`
+	Status            OpSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ClusterOpSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterOpSecret `json:"items"`
+}
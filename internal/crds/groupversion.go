@@ -0,0 +1,6 @@
+package crds
+
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
+// GroupVersion is the API group and version the opsecret resources are served under
+var GroupVersion = schema.GroupVersion{Group: "crds.driscoll.co", Version: "v1"}
@@ -15,6 +15,7 @@ import (
 //+kubebuilder:printcolumn:name="Vault",type=string,JSONPath=".spec.source.vault",description="The vault the secret is sourced from"
 //+kubebuilder:printcolumn:name="Item",type=string,JSONPath=".spec.source.item",description="The item the secret is sourced from"
 //+kubebuilder:printcolumn:name="Section",type=string,JSONPath=".spec.source.section",description="The item the secret is sourced from"
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=".status.phase",description="Whether the secret is in sync with 1Password"
 
 // OpSecret is the intention to create a secret from a 1Password item
 type OpSecret struct {
@@ -31,9 +32,23 @@ type OpSecretStatus struct {
 	Events         []Event            `json:"events,omitempty"`
 	LastReconciled metav1.Time        `json:"last-reconciled"`
 	Secrets        []Secret           `json:"secrets,omitempty"`
+	// Sources records when each 1Password source was last changed, as of the last sync
+	Sources []SourceStatus `json:"sources,omitempty"`
+	// ContentHash is a hash of the data last written to the created objects
+	ContentHash string `json:"content-hash,omitempty"`
+}
+
+type SourceStatus struct {
+	Vault   string `json:"vault"`
+	Item    string `json:"item"`
+	Section string `json:"section"`
+	// LastUpdated is the time the section last changed in 1Password
+	LastUpdated metav1.Time `json:"last-updated"`
 }
 
 type Secret struct {
+	// Kind is the kind of object created, either Secret or ConfigMap. Unpopulated means Secret
+	Kind      string `json:"kind,omitempty"`
 	Namespace string `json:"namespace"`
 	Name      string `json:"name"`
 }
@@ -47,49 +62,189 @@ type Event struct {
 	Type string `json:"type"`
 	// Message is any extra information on the event
 	Message string `json:"message"`
+	// Count is the number of reconciles in a row this event occurred in, Timestamp and OpTimestamp being the most recent
+	Count int32 `json:"count,omitempty"`
 }
 
 // OpSecretSpec contains instructions on how to source and create a secret
+// +kubebuilder:validation:XValidation:rule="(has(self.source) && size(self.source.vault) > 0) || (has(self.sources) && size(self.sources) > 0)",message="a source or sources must be set"
+// +kubebuilder:validation:XValidation:rule="!has(self.source) || (size(self.source.vault) == 0) == (size(self.source.item) == 0)",message="the source needs both a vault and an item"
+// +kubebuilder:validation:XValidation:rule="!has(self.sources) || size(self.sources) == 0 || size(self.secret.secret__dash__type) == 0 || self.secret.secret__dash__type == 'basic'",message="only basic secrets can have sources, use source"
 type OpSecretSpec struct {
 	LastUpdated metav1.Time  `json:"last-updated"`
-	Source      SourceConfig `json:"source"`
-	Secret      SecretConfig `json:"secret"`
+	Source      SourceConfig `json:"source,omitempty"`
+	// Sources are further locations within 1Password merged into the same secret, each with their own key mappings
+	// A secret key may only be produced by one source. Only basic secrets can have sources
+	// +kubebuilder:validation:MaxItems=20
+	Sources []AdditionalSource `json:"sources,omitempty"`
+	Secret  SecretConfig       `json:"secret"`
 }
 
 // SourceConfig defines the location within 1Password where the information can be found
 type SourceConfig struct {
-	Vault   string `json:"vault"`
-	Item    string `json:"item"`
-	Section string `json:"section"`
+	Vault string `json:"vault"`
+	Item  string `json:"item"`
+	// Section is the section of the item to read. Leave unpopulated to read the whole item, in which case fields are
+	// addressed as section.field, and fields in the item's default section (e.g. username, password) by their name alone.
+	// The item's built in fields are read as notes, url (the primary website), urls (every website, one per line) and
+	// otp (the otpauth:// URI of its one-time password), unless the default section has a field with the same name
+	Section string `json:"section,omitempty"`
+}
+
+// AdditionalSource is a location within 1Password along with the keys to take from it
+// +kubebuilder:validation:XValidation:rule="size(self.vault) > 0 && size(self.item) > 0",message="each source needs both a vault and an item"
+// +kubebuilder:validation:XValidation:rule="self.keys.all(k, k.to.matches('^[-._a-zA-Z0-9]+$'))",message="keys must only be mapped to secret keys made of letters, digits, '-', '_' and '.'"
+type AdditionalSource struct {
+	SourceConfig `json:",inline"`
+	// Keys maps individual keys in this source to data items within the secret
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=100
+	Keys []KeyMapping `json:"keys"`
 }
 
 type KeyMapping struct {
 	// From is the name of the key in 1Password (or for Docker, it is the name of the file to read from)
+	// +kubebuilder:validation:MinLength=1
 	From string `json:"from"`
 	// To is the name of the secret property to populate with the From value; or for Docker it is the name of the container registry hostname
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=253
 	To string `json:"to"`
 }
 
+// DockerRegistry defines the credentials for one container registry within a docker secret
+type DockerRegistry struct {
+	// Server is the hostname of the container registry
+	// +kubebuilder:validation:MinLength=1
+	Server string `json:"server"`
+	// Username is the username to log in with. Use UsernameFrom instead to read it from 1Password
+	Username string `json:"username,omitempty"`
+	// UsernameFrom is the name of the key in 1Password holding the username
+	UsernameFrom string `json:"username-from,omitempty"`
+	// PasswordFrom is the name of the key or file in 1Password holding the password or token
+	// +kubebuilder:validation:MinLength=1
+	PasswordFrom string `json:"password-from"`
+	// Email is the optional email address to include with the login
+	Email string `json:"email,omitempty"`
+}
+
+// NamespaceSelector matches namespaces by their labels and names. A namespace must match both when both are set, and
+// a selector with neither set matches every namespace
+type NamespaceSelector struct {
+	// Labels is a label selector the namespace's labels must match
+	Labels *metav1.LabelSelector `json:"labels,omitempty"`
+	// Names are glob patterns, such as team-*, at least one of which the namespace's name must match
+	// +kubebuilder:validation:MaxItems=50
+	// +kubebuilder:validation:items:MinLength=1
+	Names []string `json:"names,omitempty"`
+}
+
 // SecretConfig defines the location within Kubernetes where the secret should be created
+// +kubebuilder:validation:XValidation:rule="self.secret__dash__type != 'docker' || (has(self.registries) && size(self.registries) > 0) || (has(self.keys) && size(self.keys) > 0)",message="docker secrets need registries or keys"
+// +kubebuilder:validation:XValidation:rule="!has(self.keys) || self.keys.all(k, self.keys.exists_one(j, j.to == k.to))",message="each key must be mapped to a different secret key"
+// +kubebuilder:validation:XValidation:rule="self.secret__dash__type == 'docker' || !has(self.keys) || self.keys.all(k, k.to.matches('^[-._a-zA-Z0-9]+$'))",message="keys must only be mapped to secret keys made of letters, digits, '-', '_' and '.'"
+// +kubebuilder:validation:XValidation:rule="!has(self.registries) || self.registries.all(r, self.registries.exists_one(s, s.server == r.server))",message="each registry may only be listed once"
+// +kubebuilder:validation:XValidation:rule="!has(self.output__dash__kind) || self.output__dash__kind != 'ConfigMap' || self.secret__dash__type == 'basic'",message="only basic secrets can be written to a ConfigMap"
 type SecretConfig struct {
-	// The name of the secret
-	Name string `json:"name"`
-	// Deploy the opsecret to these namespaces
-	Namespaces []string `json:"namespaces"`
+	// The name of the secret. Defaults to the name of the opsecret
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	Name string `json:"name,omitempty"`
+	// Deploy the opsecret to these namespaces. An OpSecret listing no namespaces and without a namespace selector
+	// deploys to its own namespace
+	// +kubebuilder:validation:MaxItems=100
+	// +kubebuilder:validation:items:MaxLength=63
+	// +kubebuilder:validation:items:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +listType=set
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector additionally deploys the opsecret to every namespace it matches. Namespaces are watched, so the
+	// secret is created when a matching namespace appears and deleted when a namespace stops matching
+	NamespaceSelector *NamespaceSelector `json:"namespace-selector,omitempty"`
 	// Check this secret every N seconds in 1Password and update the secret if anything changes
+	// Values below the operator's configured minimum are raised to it
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=86400
 	RefreshSeconds int `json:"refresh-seconds"`
-	// +kubebuilder:validation:Enum=basic;docker
+	// +kubebuilder:validation:Enum=basic;docker;tls;ssh;basic-auth
 	// Type of secret. Leave unpopulated for a standard secret. Choose docker for a secret which can be used to pull images from a registry.
-	// If using 'docker' as the type you don't need to specify any keys as this will be done for you
+	// If using 'docker' as the type, list the registries to log in to rather than specifying keys
 	// Possible types:
 	//   * basic - Standard secret
-	//   * docker - Secret used for pulling images from a docker registry
+	//   * docker - Secret used for pulling images from one or more docker registries
+	//   * tls - kubernetes.io/tls secret. Map keys to tls.crt, tls.key and optionally ca.crt; the certificate and key are validated
+	//   * ssh - kubernetes.io/ssh-auth secret. Map a key to ssh-privatekey, or leave keys unpopulated to use the "private key" field of an SSH Key item
+	//   * basic-auth - kubernetes.io/basic-auth secret. Map keys to username and password, or leave keys unpopulated to use the login's fields
 	SecretType string `json:"secret-type"`
 	// Keys maps individual 1Password section keys to data items within a secret
 	// This does not need to be populated for Docker secret types as this will be calculated by the operator
+	// +kubebuilder:validation:MaxItems=100
 	Keys []KeyMapping `json:"keys,omitempty"`
+	// Templates renders Go text/template strings into data items within a secret, keyed by the data item name
+	// Each template is rendered against .Values and .Files from the 1Password section, e.g.
+	//   postgres://{{ .Values.user }}:{{ .Values.password }}@{{ .Values.host }}/app
+	// Helpers available: base64, base64Decode, toJson, indent, nindent, default, quote, trim, upper, lower
+	// A template replaces any key mapping with the same name
+	Templates map[string]string `json:"templates,omitempty"`
+	// AllKeys copies every value and file in the 1Password section into the secret without listing them in keys
+	// Key mappings and templates are applied on top and replace any key with the same name
+	AllKeys bool `json:"all-keys,omitempty"`
+	// Include limits all-keys to 1Password keys matching at least one of these glob patterns
+	Include []string `json:"include,omitempty"`
+	// Exclude skips 1Password keys matching any of these glob patterns when using all-keys
+	Exclude []string `json:"exclude,omitempty"`
+	// +kubebuilder:validation:Enum=preserve;lower;upper
+	// How 1Password keys are turned into secret keys when using all-keys. Characters which are not valid in a secret key,
+	// such as spaces, are always replaced with underscores
+	// Possible strategies:
+	//   * preserve - Keep the case of the 1Password key (default)
+	//   * lower - Lowercase the key
+	//   * upper - Uppercase the key, useful for environment variables
+	KeyNormalization string `json:"key-normalization,omitempty"`
+	// FullChain appends the certificates mapped to ca.crt onto tls.crt for tls secrets, for servers needing the full chain
+	FullChain bool `json:"full-chain,omitempty"`
+	// Labels are added to every object created
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are added to every object created
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Immutable marks every object created as immutable. As immutable objects cannot be updated, each one is named
+	// after a hash of its content, and a change in 1Password creates a new object. Pods reference the object by name,
+	// so they are not restarted: whatever deploys them must change them to use the new object, whose name is listed in
+	// the opsecret's status. The old object is kept until no pod references it, then deleted
+	Immutable bool `json:"immutable,omitempty"`
+	// Adopt takes over existing objects with the same name which were not created by an opsecret. Without it, the
+	// operator refuses to overwrite them and reports a Conflict condition
+	Adopt bool `json:"adopt,omitempty"`
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	// OutputKind is the kind of object to create. Defaults to Secret. ConfigMap can only be used with basic secrets and is
+	// intended for non-sensitive values which tools cannot read from a Secret
+	OutputKind string `json:"output-kind,omitempty"`
+	// Registries lists the container registries to log in to for docker secrets
+	// If unpopulated, each key mapping is treated as a registry logged in to as _json_key with a GCP service account key
+	// +kubebuilder:validation:MaxItems=50
+	Registries []DockerRegistry `json:"registries,omitempty"`
+	// +kubebuilder:validation:Enum=none;rollout;delete
+	// How pods using this secret are restarted when it is created or updated. Defaults to rollout.
+	// Individual workloads can opt out by setting the annotation opsecrets.crds.driscoll.co/restart: "false"
+	// Possible policies:
+	//   * none - Never restart pods, for workloads which reload the secret themselves
+	//   * rollout - Rolling restart of the Deployment, StatefulSet or DaemonSet owning the pod. Pods without one are deleted
+	//   * delete - Delete every pod using the secret
+	RestartPolicy string `json:"restart-policy,omitempty"`
 }
 
+const (
+	RestartPolicyNone    = "none"
+	RestartPolicyRollout = "rollout"
+	RestartPolicyDelete  = "delete"
+
+	KeyNormalizationPreserve = "preserve"
+	KeyNormalizationLower    = "lower"
+	KeyNormalizationUpper    = "upper"
+
+	OutputKindSecret    = "Secret"
+	OutputKindConfigMap = "ConfigMap"
+)
+
 //go:generate controller-gen object crd paths=./... output:crd:dir=../../cmd/build/helm/crds
 
 // +kubebuilder:object:root=true
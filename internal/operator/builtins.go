@@ -0,0 +1,139 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/conf"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// builtinNotes holds an item's notes
+	builtinNotes = "notes"
+	// builtinUrl holds an item's primary website, or its first if none is marked primary
+	builtinUrl = "url"
+	// builtinUrls holds every website of an item, one per line
+	builtinUrls = "urls"
+	// builtinOtp holds the otpauth:// URI of an item's one-time password, from which codes can be generated. The current
+	// code is not used as it changes every 30 seconds
+	builtinOtp = "otp"
+)
+
+// builtinFields are the parts of an item which are not fields in a section, by the key they are read with
+type builtinFields struct {
+	values      map[string]string
+	lastUpdated time.Time
+}
+
+// builtinsClient reads the built in fields of items. The 1Password client only returns the fields in an item's
+// sections, so these are read from the 1Password Connect API directly
+type builtinsClient interface {
+	ItemBuiltins(vault, item string) (builtinFields, error)
+}
+
+type connectClient struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func newConnectClient() builtinsClient {
+	return connectClient{
+		url:   strings.TrimSuffix(conf.Config.OnePassword.Api.Url, "/"),
+		token: conf.Config.OnePassword.Api.Token,
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// connectItem is the part of a 1Password Connect item holding its built in fields
+type connectItem struct {
+	Urls []struct {
+		Href    string `json:"href"`
+		Primary bool   `json:"primary"`
+	} `json:"urls"`
+	Fields []struct {
+		Type    string `json:"type"`
+		Purpose string `json:"purpose"`
+		Value   string `json:"value"`
+	} `json:"fields"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ItemBuiltins returns the notes, websites and one-time password of an item. The vault and item may be given by name or
+// by ID. Built in fields the item does not have are left out
+func (c connectClient) ItemBuiltins(vault, item string) (builtinFields, error) {
+	vaultId, err := c.findId("/v1/vaults", "name", vault)
+	if err != nil {
+		return builtinFields{}, err
+	}
+	itemId, err := c.findId(fmt.Sprintf("/v1/vaults/%s/items", url.PathEscape(vaultId)), "title", item)
+	if err != nil {
+		return builtinFields{}, err
+	}
+	full := connectItem{}
+	if err = c.get(fmt.Sprintf("/v1/vaults/%s/items/%s", url.PathEscape(vaultId), url.PathEscape(itemId)), &full); err != nil {
+		return builtinFields{}, err
+	}
+
+	builtins := builtinFields{values: make(map[string]string), lastUpdated: full.UpdatedAt}
+	urls := make([]string, 0, len(full.Urls))
+	for _, website := range full.Urls {
+		urls = append(urls, website.Href)
+		if website.Primary {
+			builtins.values[builtinUrl] = website.Href
+		}
+	}
+	if len(urls) > 0 {
+		builtins.values[builtinUrls] = strings.Join(urls, "\n")
+		if _, ok := builtins.values[builtinUrl]; !ok {
+			builtins.values[builtinUrl] = urls[0]
+		}
+	}
+	for _, field := range full.Fields {
+		switch {
+		case field.Purpose == "NOTES" && field.Value != "":
+			builtins.values[builtinNotes] = field.Value
+		case field.Type == "OTP" && field.Value != "":
+			if _, ok := builtins.values[builtinOtp]; !ok {
+				builtins.values[builtinOtp] = field.Value
+			}
+		}
+	}
+	return builtins, nil
+}
+
+// findId returns the ID of the vault or item with the given name, or the name itself if nothing has it, in which case
+// it is taken to be an ID
+func (c connectClient) findId(path, nameField, name string) (string, error) {
+	found := make([]struct {
+		Id string `json:"id"`
+	}, 0)
+	query := url.Values{"filter": []string{fmt.Sprintf("%s eq %q", nameField, name)}}
+	if err := c.get(path+"?"+query.Encode(), &found); err != nil {
+		return "", err
+	}
+	if len(found) < 1 {
+		return name, nil
+	}
+	return found[0].Id, nil
+}
+
+func (c connectClient) get(path string, into any) error {
+	req, err := http.NewRequest(http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("1Password Connect returned %d for %s", res.StatusCode, path)
+	}
+	return json.NewDecoder(res.Body).Decode(into)
+}
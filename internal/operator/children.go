@@ -0,0 +1,136 @@
+package operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "operator-opsecrets"
+	// sourceLabel identifies the opsecret an object was created from, as namespace.name
+	sourceLabel = "opsecrets.crds.driscoll.co/source"
+	// clusterSourcePrefix starts the source label of objects created from a ClusterOpSecret. Namespaces cannot contain an
+	// underscore, so the value can never be mistaken for an OpSecret's namespace.name
+	clusterSourcePrefix = "cluster_"
+	// maxLabelValueLength is the longest value Kubernetes allows for a label
+	maxLabelValueLength = 63
+)
+
+// sourceLabelValue returns the value of the source label for objects created from the opsecret, as namespace.name, or
+// the name after clusterSourcePrefix for a ClusterOpSecret. Values which would be too long for a label are shortened and
+// made unique with a hash of the full value
+func sourceLabelValue(opsecret *crds.OpSecret) string {
+	value := clusterSourcePrefix + opsecret.Name
+	if opsecret.Namespace != "" {
+		value = fmt.Sprintf("%s.%s", opsecret.Namespace, opsecret.Name)
+	}
+	if len(value) <= maxLabelValueLength {
+		return value
+	}
+	sum := sha256.Sum256([]byte(value))
+	suffix := hex.EncodeToString(sum[:])[:contentHashLength]
+	return value[:maxLabelValueLength-len(suffix)-1] + "-" + suffix
+}
+
+// childLabels returns the labels identifying an object as created from the opsecret
+func childLabels(opsecret *crds.OpSecret) map[string]string {
+	return map[string]string{
+		managedByLabel: managedBy,
+		sourceLabel:    sourceLabelValue(opsecret),
+	}
+}
+
+// findChildren returns every object created from the opsecret, found by its labels, along with any objects recorded in
+// its status which were created before the labels were added
+func findChildren(ctx context.Context, opsecret *crds.OpSecret, k8sClient client.Client) ([]client.Object, error) {
+	children := make([]client.Object, 0)
+	seen := make(map[string]bool)
+	add := func(kind string, child client.Object) {
+		key := fmt.Sprintf("%s/%s/%s", outputKind(kind), child.GetNamespace(), child.GetName())
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		children = append(children, child)
+	}
+
+	selector := client.MatchingLabels(childLabels(opsecret))
+	secrets := &corev1.SecretList{}
+	if err := k8sClient.List(ctx, secrets, selector); err != nil {
+		return nil, fmt.Errorf("could not list secrets : %w", err)
+	}
+	for i := range secrets.Items {
+		add(crds.OutputKindSecret, &secrets.Items[i])
+	}
+	configMaps := &corev1.ConfigMapList{}
+	if err := k8sClient.List(ctx, configMaps, selector); err != nil {
+		return nil, fmt.Errorf("could not list config maps : %w", err)
+	}
+	for i := range configMaps.Items {
+		add(crds.OutputKindConfigMap, &configMaps.Items[i])
+	}
+
+	for _, recorded := range opsecret.Status.Secrets {
+		if seen[fmt.Sprintf("%s/%s/%s", outputKind(recorded.Kind), recorded.Namespace, recorded.Name)] {
+			continue
+		}
+		child := newOutput(recorded.Kind)
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: recorded.Namespace, Name: recorded.Name}, child)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s : %w", describeOutput(recorded.Kind, recorded.Namespace, recorded.Name), err)
+		}
+		add(recorded.Kind, child)
+	}
+	return children, nil
+}
+
+// ownsChild reports whether an existing object was created from the opsecret, either because it carries the opsecret's
+// source label or because it is recorded in the opsecret's status from before the labels were added
+func ownsChild(opsecret *crds.OpSecret, kind string, child client.Object) bool {
+	if child.GetLabels()[sourceLabel] == sourceLabelValue(opsecret) {
+		return true
+	}
+	for _, recorded := range opsecret.Status.Secrets {
+		if outputKind(recorded.Kind) == kind && recorded.Namespace == child.GetNamespace() && recorded.Name == child.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+// canAdopt reports whether the opsecret may take over an existing object it did not create. Objects created from
+// another opsecret are never adopted, so two opsecrets cannot fight over the same object
+func canAdopt(opsecret *crds.OpSecret, child client.Object) bool {
+	if !opsecret.Spec.Secret.Adopt {
+		return false
+	}
+	_, managed := child.GetLabels()[sourceLabel]
+	return !managed
+}
+
+// opsecretLocation returns the opsecret as namespace/name, or just the name for a ClusterOpSecret
+func opsecretLocation(opsecret *crds.OpSecret) string {
+	if opsecret.Namespace == "" {
+		return opsecret.Name
+	}
+	return fmt.Sprintf("%s/%s", opsecret.Namespace, opsecret.Name)
+}
+
+// kindOf returns the output kind of an object created from an opsecret
+func kindOf(child client.Object) string {
+	if _, ok := child.(*corev1.ConfigMap); ok {
+		return crds.OutputKindConfigMap
+	}
+	return crds.OutputKindSecret
+}
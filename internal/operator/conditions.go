@@ -0,0 +1,153 @@
+package operator
+
+import (
+	"errors"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+const (
+	// conditionReady is true when every object the opsecret describes is in sync with 1Password
+	conditionReady = "Ready"
+	// conditionSourceAvailable is true when every 1Password source could be fetched
+	conditionSourceAvailable = "SourceAvailable"
+	// conditionSynced is true when the objects were built from the latest 1Password data and written
+	conditionSynced = "Synced"
+	// conditionDegraded is true when the objects were written to some but not all of the namespaces
+	conditionDegraded = "Degraded"
+	// conditionConflict is true when an object the opsecret should write to already exists and belongs to something else
+	conditionConflict = "Conflict"
+
+	reasonSynced           = "Synced"
+	reasonFetched          = "Fetched"
+	reasonItemNotFound     = "ItemNotFound"
+	reasonSectionNotFound  = "SectionNotFound"
+	reasonApiUnauthorized  = "ApiUnauthorized"
+	reasonApiError         = "ApiError"
+	reasonKeyMissing       = "KeyMissing"
+	reasonFileFetchFailed  = "FileFetchFailed"
+	reasonFieldCollision   = "FieldCollision"
+	reasonInvalidData      = "InvalidData"
+	reasonInvalidSpec      = "InvalidSpec"
+	reasonNamespaceMissing = "NamespaceMissing"
+	reasonNamespaceDenied  = "NamespaceNotAllowed"
+	reasonNoNamespaces     = "NoNamespaces"
+	reasonNotOwned         = "NotOwned"
+	reasonNoConflict       = "NoConflict"
+	reasonAllNamespaces    = "AllNamespaces"
+
+	phaseReady    = "Ready"
+	phaseDegraded = "Degraded"
+	phaseFailed   = "Failed"
+)
+
+func setCondition(opsecret *crds.OpSecret, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&opsecret.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: opsecret.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// reasonFor returns the condition reason describing why the 1Password data could not be fetched or used
+func reasonFor(err error) string {
+	switch {
+	case errors.Is(err, errApiUnauthorized):
+		return reasonApiUnauthorized
+	case errors.Is(err, errItemNotFound):
+		return reasonItemNotFound
+	case errors.Is(err, errSectionNotFound):
+		return reasonSectionNotFound
+	case errors.Is(err, errKeyMissing):
+		return reasonKeyMissing
+	case errors.Is(err, errFileFetchFailed):
+		return reasonFileFetchFailed
+	case errors.Is(err, errFieldCollision):
+		return reasonFieldCollision
+	case errors.Is(err, errInvalidSpec):
+		return reasonInvalidSpec
+	}
+	return reasonInvalidData
+}
+
+// setSourceUnavailable records that a 1Password source could not be fetched, so nothing could be synced
+func setSourceUnavailable(opsecret *crds.OpSecret, err error) {
+	reason := reasonFor(err)
+	if reason == reasonInvalidData {
+		reason = reasonApiError
+	}
+	setCondition(opsecret, conditionSourceAvailable, metav1.ConditionFalse, reason, err.Error())
+	setNotSynced(opsecret, reason, err.Error())
+}
+
+// setSyncFailed records that the 1Password data was fetched but the objects could not be built from it
+func setSyncFailed(opsecret *crds.OpSecret, err error) {
+	setCondition(opsecret, conditionSourceAvailable, metav1.ConditionTrue, reasonFetched, "")
+	setNotSynced(opsecret, reasonFor(err), err.Error())
+}
+
+// setInvalid records that the opsecret's spec is invalid, so 1Password was not read
+func setInvalid(opsecret *crds.OpSecret, err error) {
+	setCondition(opsecret, conditionSourceAvailable, metav1.ConditionUnknown, reasonInvalidSpec, "")
+	setNotSynced(opsecret, reasonInvalidSpec, err.Error())
+}
+
+func setNotSynced(opsecret *crds.OpSecret, reason, message string) {
+	setCondition(opsecret, conditionSynced, metav1.ConditionFalse, reason, message)
+	setCondition(opsecret, conditionDegraded, metav1.ConditionFalse, reason, "")
+	setCondition(opsecret, conditionReady, metav1.ConditionFalse, reason, message)
+	opsecret.Status.Phase = phaseFailed
+}
+
+// setSynced records the outcome of writing the objects. Namespaces which could not be written to, because they are not
+// allowed, do not exist or the object there belongs to something else, leave the opsecret degraded rather than failed.
+// It fails only if none of the allowed namespaces could be written to
+func setSynced(opsecret *crds.OpSecret, namespaces, denied, missing, conflicts []string) {
+	setCondition(opsecret, conditionSourceAvailable, metav1.ConditionTrue, reasonFetched, "")
+	if len(conflicts) > 0 {
+		setCondition(opsecret, conditionConflict, metav1.ConditionTrue, reasonNotOwned,
+			"existing objects were not created by this opsecret, set adopt to take them over: "+strings.Join(conflicts, ", "))
+	} else {
+		setCondition(opsecret, conditionConflict, metav1.ConditionFalse, reasonNoConflict, "")
+	}
+
+	if len(namespaces)+len(denied) < 1 {
+		setNotSynced(opsecret, reasonNoNamespaces, "no namespaces are listed or matched by the namespace selector")
+		return
+	}
+
+	reason, problems := reasonSynced, make([]string, 0)
+	if len(denied) > 0 {
+		reason = reasonNamespaceDenied
+		problems = append(problems, "OpSecrets may only create secrets in their own namespace, existing secrets are left "+
+			"as they are, use a ClusterOpSecret for: "+strings.Join(denied, ", "))
+	}
+	if len(missing) > 0 {
+		reason = reasonNamespaceMissing
+		problems = append(problems, "namespaces do not exist: "+strings.Join(missing, ", "))
+	}
+	if len(conflicts) > 0 {
+		reason = reasonNotOwned
+		problems = append(problems, "objects are not owned by this opsecret: "+strings.Join(conflicts, ", "))
+	}
+	if len(problems) > 0 && len(namespaces) > 0 && len(missing)+len(conflicts) >= len(namespaces) {
+		// Nothing was written to an allowed namespace
+		setNotSynced(opsecret, reason, strings.Join(problems, "; "))
+		return
+	}
+
+	setCondition(opsecret, conditionSynced, metav1.ConditionTrue, reasonSynced, "")
+	if len(problems) > 0 {
+		setCondition(opsecret, conditionDegraded, metav1.ConditionTrue, reason, strings.Join(problems, "; "))
+		setCondition(opsecret, conditionReady, metav1.ConditionFalse, reason, strings.Join(problems, "; "))
+		opsecret.Status.Phase = phaseDegraded
+		return
+	}
+	setCondition(opsecret, conditionDegraded, metav1.ConditionFalse, reasonAllNamespaces, "")
+	setCondition(opsecret, conditionReady, metav1.ConditionTrue, reasonSynced, "")
+	opsecret.Status.Phase = phaseReady
+}
@@ -0,0 +1,87 @@
+package operator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	onepassword "github.com/driscollco-cluster/1password"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// legacyDockerUsername is used for registries defined through key mappings, which hold a GCP service account key
+const legacyDockerUsername = "_json_key"
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth"`
+}
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+func (o operator) getDockerSecret(opsecret *crds.OpSecret, section onepassword.Section) (*corev1.Secret, error) {
+	if opsecret.Spec.Secret.SecretType != "docker" {
+		return nil, errors.New("wrong secret type: " + opsecret.Spec.Secret.SecretType)
+	}
+
+	registries := opsecret.Spec.Secret.Registries
+	if len(registries) < 1 {
+		for _, key := range opsecret.Spec.Secret.Keys {
+			registries = append(registries, crds.DockerRegistry{
+				Server:       key.To,
+				Username:     legacyDockerUsername,
+				PasswordFrom: key.From,
+			})
+		}
+	}
+	if len(registries) < 1 {
+		return nil, errors.New("no registries or keys defined")
+	}
+
+	config := dockerConfig{Auths: make(map[string]dockerAuth)}
+	for _, registry := range registries {
+		if _, exists := config.Auths[registry.Server]; exists {
+			return nil, fmt.Errorf("registry defined more than once: %s", registry.Server)
+		}
+		username := registry.Username
+		if registry.UsernameFrom != "" {
+			value, err := o.getKey(registry.UsernameFrom, section)
+			if err != nil {
+				return nil, fmt.Errorf("username for registry %s : %w", registry.Server, err)
+			}
+			username = value
+		}
+		password, err := o.getKey(registry.PasswordFrom, section)
+		if err != nil {
+			return nil, fmt.Errorf("password for registry %s : %w", registry.Server, err)
+		}
+		config.Auths[registry.Server] = dockerAuth{
+			Username: username,
+			Password: password,
+			Email:    registry.Email,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+		}
+	}
+
+	dockerConfigJson, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the Secret
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName(opsecret),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: dockerConfigJson,
+		},
+	}, nil
+}
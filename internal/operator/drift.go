@@ -0,0 +1,110 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	"github.com/driscollco-core/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+// driftAnnotation is set on an opsecret when an object created from it is changed or deleted, which causes the
+// opsecret to be reconciled straight away
+const driftAnnotation = "opsecrets.crds.driscoll.co/drift-detected"
+
+// findDrift returns each object created from the opsecret which has since been deleted or had its data changed
+func (o operator) findDrift(ctx context.Context, opsecret *crds.OpSecret, namespaces []string, k8sClient client.Client) ([]string, error) {
+	drift := make([]string, 0)
+	for _, recorded := range opsecret.Status.Secrets {
+		if o.shouldBeDeleted(opsecret, namespaces, &recorded) {
+			continue
+		}
+		child := newOutput(recorded.Kind)
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: recorded.Namespace, Name: recorded.Name}, child)
+		if apierrors.IsNotFound(err) {
+			drift = append(drift, fmt.Sprintf("%s/%s was deleted", recorded.Namespace, recorded.Name))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s : %w", describeOutput(recorded.Kind, recorded.Namespace, recorded.Name), err)
+		}
+		if drifted(child) || contentHash(child) != opsecret.Status.ContentHash {
+			drift = append(drift, fmt.Sprintf("%s/%s was modified", recorded.Namespace, recorded.Name))
+		}
+	}
+	return drift, nil
+}
+
+// WatchChildren watches every secret and config map created by the operator and, when one is modified or deleted by
+// anything else, marks its opsecret so that it is reconciled and the object restored without waiting for the next refresh
+func WatchChildren(ctx context.Context, k8sCache cache.Cache, k8sClient client.Client, log log.Log) error {
+	handler := toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, newObj interface{}) {
+			child, ok := newObj.(client.Object)
+			if !ok || !drifted(child) {
+				return
+			}
+			markDrifted(ctx, child, k8sClient, log)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if child, ok := obj.(client.Object); ok {
+				markDrifted(ctx, child, k8sClient, log)
+			}
+		},
+	}
+
+	for _, object := range []client.Object{&corev1.Secret{}, &corev1.ConfigMap{}} {
+		informer, err := k8sCache.GetInformer(ctx, object)
+		if err != nil {
+			return err
+		}
+		if _, err = informer.AddEventHandler(handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func markDrifted(ctx context.Context, child client.Object, k8sClient client.Client, log log.Log) {
+	source, ok := child.GetAnnotations()[sourceAnnotation]
+	if !ok {
+		return
+	}
+	namespace, name, found := strings.Cut(source, "/")
+	if !found {
+		// Created from a ClusterOpSecret
+		namespace, name = "", source
+	}
+
+	if err := markOpSecret(ctx, namespace, name, driftAnnotation, k8sClient); err != nil {
+		log.Error("unable to mark opsecret as drifted", "error", err.Error(),
+			"opsecret.location", source,
+			"secret.location", fmt.Sprintf("%s/%s", child.GetNamespace(), child.GetName()))
+	}
+}
+
+// markOpSecret sets an annotation on an opsecret to the current time, which causes it to be reconciled straight away.
+// An opsecret without a namespace is a ClusterOpSecret
+func markOpSecret(ctx context.Context, namespace, name, annotation string, k8sClient client.Client) error {
+	kind := "OpSecret"
+	if namespace == "" {
+		kind = "ClusterOpSecret"
+	}
+	opsecret := &metav1.PartialObjectMetadata{}
+	opsecret.SetGroupVersionKind(crds.GroupVersion.WithKind(kind))
+	opsecret.SetNamespace(namespace)
+	opsecret.SetName(name)
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, annotation, time.Now().Format(time.RFC3339Nano))
+	return client.IgnoreNotFound(k8sClient.Patch(ctx, opsecret, client.RawPatch(types.MergePatchType, []byte(patch))))
+}
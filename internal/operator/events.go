@@ -0,0 +1,89 @@
+package operator
+
+import (
+	"github.com/driscollco-cluster/operator-1password/internal/conf"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"time"
+)
+
+const (
+	eventCreate = "create"
+	eventUpdate = "update"
+	eventDrift  = "drift"
+	eventError  = "error"
+)
+
+// eventReasons are the reasons given to the Kubernetes events emitted alongside each type of status event
+var eventReasons = map[string]string{
+	eventCreate: "Created",
+	eventUpdate: "Updated",
+	eventDrift:  "Drift",
+	eventError:  "SyncFailed",
+}
+
+// recordEvent adds an event to the opsecret's status history and emits it as a Kubernetes event on the object, the
+// OpSecret or ClusterOpSecret the opsecret is a view of. Every event from one reconcile is given the same occurred time.
+// An event of the same type as the previous one from the same reconcile, such as a secret being created in another
+// namespace, is merged into it. Once the reconcile is done, finishEvents counts an event repeating the one before
+func recordEvent(object runtime.Object, opsecret *crds.OpSecret, recorder record.EventRecorder, eventType, message string,
+	opTimestamp, occurred time.Time) {
+	kubernetesType := corev1.EventTypeNormal
+	if eventType == eventDrift || eventType == eventError {
+		kubernetesType = corev1.EventTypeWarning
+	}
+	recorder.Event(object, kubernetesType, eventReasons[eventType], message)
+
+	if last := len(opsecret.Status.Events) - 1; last >= 0 {
+		previous := &opsecret.Status.Events[last]
+		if previous.Type == eventType && previous.Timestamp.Time.Equal(occurred) {
+			previous.Message += "; " + message
+			if opTimestamp.After(previous.OpTimestamp.Time) {
+				previous.OpTimestamp = metav1.NewTime(opTimestamp)
+			}
+			return
+		}
+	}
+	opsecret.Status.Events = append(opsecret.Status.Events, crds.Event{
+		Timestamp:   metav1.NewTime(occurred),
+		OpTimestamp: metav1.NewTime(opTimestamp),
+		Type:        eventType,
+		Message:     message,
+		Count:       1,
+	})
+}
+
+// finishEvents is called once a reconcile has recorded all of its events. If the last event repeats the one before it,
+// from an earlier reconcile, it is counted against that one with its times rather than kept. Old events are then pruned
+func finishEvents(opsecret *crds.OpSecret) {
+	if last := len(opsecret.Status.Events) - 1; last > 0 {
+		latest, previous := opsecret.Status.Events[last], &opsecret.Status.Events[last-1]
+		if latest.Type == previous.Type && latest.Message == previous.Message {
+			previous.Timestamp = latest.Timestamp
+			previous.OpTimestamp = latest.OpTimestamp
+			previous.Count = max(previous.Count, 1) + 1
+			opsecret.Status.Events = opsecret.Status.Events[:last]
+		}
+	}
+	pruneEvents(opsecret)
+}
+
+// pruneEvents drops events older than the configured maximum age, then the oldest events beyond the configured maximum
+// count. A limit of zero or less is not applied
+func pruneEvents(opsecret *crds.OpSecret) {
+	events := make([]crds.Event, 0, len(opsecret.Status.Events))
+	maxAge := time.Second * time.Duration(conf.Config.Secrets.Events.MaxAgeSeconds)
+	for _, event := range opsecret.Status.Events {
+		if maxAge > 0 && time.Since(event.Timestamp.Time) > maxAge {
+			continue
+		}
+		events = append(events, event)
+	}
+	if maxCount := conf.Config.Secrets.Events.MaxCount; maxCount > 0 && len(events) > maxCount {
+		events = events[len(events)-maxCount:]
+	}
+	opsecret.Status.Events = events
+}
@@ -0,0 +1,81 @@
+package operator
+
+import (
+	"fmt"
+	onepassword "github.com/driscollco-cluster/1password"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// invalidKeyCharacters matches anything which cannot be used in the key of a secret's data
+var invalidKeyCharacters = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// getAllKeys returns every value and file in the 1Password section which passes the opsecret's include and exclude
+// patterns, keyed by the normalised 1Password key
+func (o operator) getAllKeys(opsecret *crds.OpSecret, section onepassword.Section) (map[string]string, error) {
+	data := make(map[string]string)
+	sources := make(map[string]string)
+	add := func(from, value string) error {
+		to := normaliseKey(from, opsecret.Spec.Secret.KeyNormalization)
+		if existing, ok := sources[to]; ok {
+			return fmt.Errorf("1Password keys %s and %s both normalise to secret key %s", existing, from, to)
+		}
+		sources[to] = from
+		data[to] = value
+		return nil
+	}
+
+	for key, value := range section.Values {
+		if !keySelected(key, opsecret.Spec.Secret.Include, opsecret.Spec.Secret.Exclude) {
+			continue
+		}
+		if err := add(key, value.Value); err != nil {
+			return nil, err
+		}
+	}
+	for name, file := range section.Files {
+		if !keySelected(name, opsecret.Spec.Secret.Include, opsecret.Spec.Secret.Exclude) {
+			continue
+		}
+		fileContent, err := o.client.FileContent(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s : %w", errFileFetchFailed, file.Name, err)
+		}
+		if err = add(name, string(fileContent)); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// keySelected reports whether a 1Password key matches at least one include pattern (if there are any) and no exclude pattern
+func keySelected(key string, include, exclude []string) bool {
+	for _, pattern := range exclude {
+		if matched, _ := path.Match(pattern, key); matched {
+			return false
+		}
+	}
+	if len(include) < 1 {
+		return true
+	}
+	for _, pattern := range include {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// normaliseKey turns a 1Password key into a valid secret data key using the given strategy
+func normaliseKey(key, strategy string) string {
+	normalised := invalidKeyCharacters.ReplaceAllString(strings.TrimSpace(key), "_")
+	switch strategy {
+	case crds.KeyNormalizationLower:
+		return strings.ToLower(normalised)
+	case crds.KeyNormalizationUpper:
+		return strings.ToUpper(normalised)
+	}
+	return normalised
+}
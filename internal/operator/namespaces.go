@@ -0,0 +1,177 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/conf"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	"github.com/driscollco-core/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"maps"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+)
+
+// namespacesChangedAnnotation is set on an opsecret when a namespace starts or stops matching its namespace selector,
+// which causes the opsecret to be reconciled straight away
+const namespacesChangedAnnotation = "opsecrets.crds.driscoll.co/namespaces-changed"
+
+// targetNamespaces returns every namespace the opsecret writes to: those it lists, followed by those matched by its
+// namespace selector. Namespaces being deleted are never matched.
+// Unless cross namespace secrets are allowed, an OpSecret may only write to its own namespace; the other namespaces it
+// lists are returned as denied, and the other namespaces its selector matches are ignored. ClusterOpSecrets may write
+// to any namespace.
+// An OpSecret listing no namespaces and without a selector writes to its own namespace
+func (o operator) targetNamespaces(ctx context.Context, opsecret *crds.OpSecret) ([]string, []string, error) {
+	listed := opsecret.Spec.Secret.Namespaces
+	if len(listed) < 1 && opsecret.Spec.Secret.NamespaceSelector == nil && opsecret.Namespace != "" {
+		listed = []string{opsecret.Namespace}
+	}
+	namespaces, denied := make([]string, 0), make([]string, 0)
+	for _, namespace := range listed {
+		if !namespaceAllowed(opsecret, namespace) {
+			denied = append(denied, namespace)
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	selector := opsecret.Spec.Secret.NamespaceSelector
+	if selector == nil {
+		return namespaces, denied, nil
+	}
+
+	namespaceList := &corev1.NamespaceList{}
+	if err := o.cached.List(ctx, namespaceList); err != nil {
+		return nil, nil, fmt.Errorf("could not list namespaces : %w", err)
+	}
+	for i := range namespaceList.Items {
+		namespace := &namespaceList.Items[i]
+		if !namespace.DeletionTimestamp.IsZero() || !namespaceAllowed(opsecret, namespace.Name) || slices.Contains(namespaces, namespace.Name) {
+			continue
+		}
+		matched, err := namespaceMatches(selector, namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		if matched {
+			namespaces = append(namespaces, namespace.Name)
+		}
+	}
+	return namespaces, denied, nil
+}
+
+// namespaceAllowed reports whether the opsecret may write to a namespace
+func namespaceAllowed(opsecret *crds.OpSecret, namespace string) bool {
+	return opsecret.Namespace == "" || namespace == opsecret.Namespace || conf.Config.Secrets.AllowCrossNamespace
+}
+
+// namespaceMatches reports whether a namespace matches both the label selector and, if any are set, one of the name
+// patterns of a namespace selector
+func namespaceMatches(selector *crds.NamespaceSelector, namespace *corev1.Namespace) (bool, error) {
+	if selector.Labels != nil {
+		labelSelector, err := metav1.LabelSelectorAsSelector(selector.Labels)
+		if err != nil {
+			return false, fmt.Errorf("invalid namespace label selector : %w", err)
+		}
+		if !labelSelector.Matches(labels.Set(namespace.Labels)) {
+			return false, nil
+		}
+	}
+	if len(selector.Names) < 1 {
+		return true, nil
+	}
+	for _, pattern := range selector.Names {
+		matched, err := path.Match(pattern, namespace.Name)
+		if err != nil {
+			return false, fmt.Errorf("invalid namespace name pattern %s : %w", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// WatchNamespaces watches namespaces being created, relabelled and deleted and marks every opsecret whose namespace
+// selector they start or stop matching, so that its secrets are created or deleted without waiting for the next refresh
+func WatchNamespaces(ctx context.Context, k8sCache cache.Cache, k8sClient client.Client, log log.Log) error {
+	handler := toolscache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			// Opsecrets are reconciled on start, so the namespaces which already exist are picked up then
+			if namespace, ok := obj.(*corev1.Namespace); ok && !isInInitialList {
+				markSelecting(ctx, nil, namespace, k8sClient, log)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok := oldObj.(*corev1.Namespace)
+			if !ok {
+				return
+			}
+			after, ok := newObj.(*corev1.Namespace)
+			if !ok || (maps.Equal(before.Labels, after.Labels) && before.DeletionTimestamp.IsZero() == after.DeletionTimestamp.IsZero()) {
+				return
+			}
+			markSelecting(ctx, before, after, k8sClient, log)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if namespace, ok := obj.(*corev1.Namespace); ok {
+				markSelecting(ctx, namespace, nil, k8sClient, log)
+			}
+		},
+	}
+
+	informer, err := k8sCache.GetInformer(ctx, &corev1.Namespace{})
+	if err != nil {
+		return err
+	}
+	_, err = informer.AddEventHandler(handler)
+	return err
+}
+
+// markSelecting marks every opsecret whose namespace selector matched the namespace before a change but not after it,
+// or the other way around. Either namespace may be nil, for one which was created or deleted
+func markSelecting(ctx context.Context, before, after *corev1.Namespace, k8sClient client.Client, log log.Log) {
+	// The client has no scheme for opsecrets, so they are listed unstructured and converted
+	items := make([]unstructured.Unstructured, 0)
+	for _, kind := range []string{"OpSecretList", "ClusterOpSecretList"} {
+		opsecrets := &unstructured.UnstructuredList{}
+		opsecrets.SetGroupVersionKind(crds.GroupVersion.WithKind(kind))
+		if err := k8sClient.List(ctx, opsecrets); err != nil {
+			log.Error("unable to list opsecrets", "error", err.Error(), "kind", kind)
+			continue
+		}
+		items = append(items, opsecrets.Items...)
+	}
+
+	matches := func(selector *crds.NamespaceSelector, namespace *corev1.Namespace) bool {
+		if namespace == nil || !namespace.DeletionTimestamp.IsZero() {
+			return false
+		}
+		matched, err := namespaceMatches(selector, namespace)
+		return err == nil && matched
+	}
+	for _, item := range items {
+		opsecret := &crds.OpSecret{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, opsecret); err != nil {
+			continue
+		}
+		selector := opsecret.Spec.Secret.NamespaceSelector
+		if selector == nil || matches(selector, before) == matches(selector, after) {
+			continue
+		}
+		if err := markOpSecret(ctx, opsecret.Namespace, opsecret.Name, namespacesChangedAnnotation, k8sClient); err != nil {
+			log.Error("unable to mark opsecret for a namespace change", "error", err.Error(),
+				"opsecret.location", opsecretLocation(opsecret))
+		}
+	}
+}
@@ -2,7 +2,6 @@ package operator
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	onepassword "github.com/driscollco-cluster/1password"
@@ -11,37 +10,46 @@ import (
 	"github.com/driscollco-core/log"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
-	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"slices"
 	"strings"
 	"time"
 )
 
 const (
-	finalizer       = "opsecrets.crds.driscoll.co"
-	errorToSuppress = "resourceVersion should not be set on objects to be created"
+	finalizer = "opsecrets.crds.driscoll.co"
+	// fieldManager owns the fields the operator sets on the objects it applies
+	fieldManager = "operator-opsecrets"
 )
 
 type Operator interface {
 	Reconcile(ctx context.Context, req ctrl.Request, k8sClient client.Client, recorder record.EventRecorder, scheme *runtime.Scheme) (ctrl.Result, error)
+	ReconcileCluster(ctx context.Context, req ctrl.Request, k8sClient client.Client, recorder record.EventRecorder, scheme *runtime.Scheme) (ctrl.Result, error)
 }
 
-func New(log log.Log) Operator {
+func New(log log.Log, cached client.Reader) Operator {
 	return operator{
-		client: onepassword.NewClient(conf.Config.OnePassword.Api.Url, conf.Config.OnePassword.Api.Token),
-		log:    log,
+		client:   onepassword.NewClient(conf.Config.OnePassword.Api.Url, conf.Config.OnePassword.Api.Token),
+		builtins: newConnectClient(),
+		log:      log,
+		cached:   cached,
 	}
 }
 
 type operator struct {
 	client onepassword.Client
-	log    log.Log
+	// builtins reads the notes, websites and one-time passwords of items, which client does not return
+	builtins builtinsClient
+	log      log.Log
+	// cached reads pods and namespaces from the cache created by NewCache
+	cached client.Reader
 }
 
 func (o operator) Reconcile(ctx context.Context, req ctrl.Request, k8sClient client.Client, recorder record.EventRecorder, scheme *runtime.Scheme) (ctrl.Result, error) {
@@ -49,33 +57,45 @@ func (o operator) Reconcile(ctx context.Context, req ctrl.Request, k8sClient cli
 	if err := k8sClient.Get(ctx, req.NamespacedName, opsecret); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	return o.reconcile(ctx, opsecret, opsecret, k8sClient, recorder, scheme)
+}
+
+// ReconcileCluster reconciles a ClusterOpSecret. As its fields match an OpSecret's, it is reconciled as one, with the
+// ClusterOpSecret itself used wherever the object is written to or referenced
+func (o operator) ReconcileCluster(ctx context.Context, req ctrl.Request, k8sClient client.Client, recorder record.EventRecorder, scheme *runtime.Scheme) (ctrl.Result, error) {
+	clusterOpsecret := &crds.ClusterOpSecret{}
+	if err := k8sClient.Get(ctx, req.NamespacedName, clusterOpsecret); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return o.reconcile(ctx, clusterOpsecret, (*crds.OpSecret)(clusterOpsecret), k8sClient, recorder, scheme)
+}
+
+// reconcile syncs an opsecret with 1Password. The object is the OpSecret or ClusterOpSecret being reconciled, and the
+// opsecret is a view of the same object
+func (o operator) reconcile(ctx context.Context, object client.Object, opsecret *crds.OpSecret, k8sClient client.Client,
+	recorder record.EventRecorder, scheme *runtime.Scheme) (ctrl.Result, error) {
 	theLog := o.log.Child(
 		"secret.source", fmt.Sprintf("%s/%s/%s", opsecret.Spec.Source.Vault, opsecret.Spec.Source.Item, opsecret.Spec.Source.Section),
-		"opsecret.location", fmt.Sprintf("%s/%s", req.Namespace, req.Name))
+		"opsecret.location", opsecretLocation(opsecret))
 
 	if !opsecret.ObjectMeta.DeletionTimestamp.IsZero() {
 		if controllerutil.ContainsFinalizer(opsecret, finalizer) {
-			theLog.Info(fmt.Sprintf("deleted opsecret : %s/%s", opsecret.Namespace, opsecret.Name))
-			for _, namespace := range opsecret.Spec.Secret.Namespaces {
-				childSecret := &corev1.Secret{}
-				secretKey := types.NamespacedName{
-					Name:      opsecret.Spec.Secret.Name,
-					Namespace: namespace,
-				}
-				if err := k8sClient.Get(ctx, secretKey, childSecret); err != nil {
-					theLog.Error("unable to fetch child secret", "error", err.Error(),
-						"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
-					continue
-				}
-				if err := k8sClient.Delete(ctx, childSecret); err != nil {
+			theLog.Info("deleted opsecret : " + opsecretLocation(opsecret))
+			children, err := findChildren(ctx, opsecret, k8sClient)
+			if err != nil {
+				theLog.Error("unable to find child secrets", "error", err.Error())
+				return ctrl.Result{}, err
+			}
+			for _, childSecret := range children {
+				if err := k8sClient.Delete(ctx, childSecret); client.IgnoreNotFound(err) != nil {
 					theLog.Error("error deleting child secret", "error", err.Error(),
-						"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
+						"secret.location", fmt.Sprintf("%s/%s", childSecret.GetNamespace(), childSecret.GetName()))
 					continue
 				}
-				theLog.Info(fmt.Sprintf("deleted secret : %s/%s", namespace, opsecret.Spec.Secret.Name))
+				theLog.Info("deleted " + describeOutput(kindOf(childSecret), childSecret.GetNamespace(), childSecret.GetName()))
 			}
 			controllerutil.RemoveFinalizer(opsecret, finalizer)
-			if err := k8sClient.Update(ctx, opsecret); err != nil {
+			if err := k8sClient.Update(ctx, object); err != nil {
 				theLog.Error("error removing finalizer for opsecret", "error", err.Error())
 				return ctrl.Result{}, err
 			}
@@ -87,11 +107,11 @@ func (o operator) Reconcile(ctx context.Context, req ctrl.Request, k8sClient cli
 
 	if !controllerutil.ContainsFinalizer(opsecret, finalizer) {
 		controllerutil.AddFinalizer(opsecret, finalizer)
-		if err := k8sClient.Update(ctx, opsecret); err != nil {
+		if err := k8sClient.Update(ctx, object); err != nil {
 			theLog.Error("error setting finalizer for opsecret", "error", err.Error())
 			return ctrl.Result{}, err
 		}
-		theLog.Info(fmt.Sprintf("created opsecret : %s/%s", opsecret.Namespace, opsecret.Name))
+		theLog.Info("created opsecret : " + opsecretLocation(opsecret))
 		return o.getRequeue(opsecret), nil
 	}
 
@@ -99,137 +119,209 @@ func (o operator) Reconcile(ctx context.Context, req ctrl.Request, k8sClient cli
 		opsecret.Status.Events = []crds.Event{}
 	}
 
-	item, err := o.client.GetItem(opsecret.Spec.Source.Vault, opsecret.Spec.Source.Item)
+	if err := Validate(opsecret); err != nil {
+		theLog.Error("opsecret is invalid", "error", err.Error())
+		setInvalid(opsecret, err)
+		return ctrl.Result{}, o.recordFailure(ctx, object, opsecret, k8sClient, recorder, theLog, err)
+	}
+
+	sources, err := o.getSources(opsecret)
+	if err != nil {
+		setSourceUnavailable(opsecret, err)
+		if statusErr := o.recordFailure(ctx, object, opsecret, k8sClient, recorder, theLog, err); statusErr != nil {
+			return ctrl.Result{}, statusErr
+		}
+	}
+	if errors.Is(err, errSectionNotFound) {
+		theLog.Info("section was not found when looking for updates to secret", "error", err.Error())
+		return o.getRequeue(opsecret), nil
+	}
 	if err != nil {
 		theLog.Info("error fetching item from 1Password", "error", err.Error())
 		return ctrl.Result{}, err
 	}
-
-	section, ok := item.Content[opsecret.Spec.Source.Section]
-	if !ok {
-		theLog.Info("section was not found when looking for updates to secret")
-		return o.getRequeue(opsecret), nil
+	namespaces, denied, err := o.targetNamespaces(ctx, opsecret)
+	if err != nil {
+		theLog.Error("unable to find the namespaces to create secrets in", "error", err.Error())
+		return ctrl.Result{}, err
 	}
-	if !o.updateRequired(opsecret, section) {
-		return o.getRequeue(opsecret), nil
+	// Every event from this reconcile shares a time, so those of the same type can be merged
+	reconciled := time.Now()
+	if !o.updateRequired(ctx, opsecret, namespaces, sources) {
+		drift, err := o.findDrift(ctx, opsecret, namespaces, k8sClient)
+		if err != nil {
+			theLog.Error("error checking child secrets for drift", "error", err.Error())
+			return ctrl.Result{}, err
+		}
+		if len(drift) < 1 {
+			return o.getRequeue(opsecret), nil
+		}
+		for _, drifted := range drift {
+			theLog.Info("restoring child secret changed outside of the operator", "drift", drifted)
+		}
+		recordEvent(object, opsecret, recorder, eventDrift,
+			"restoring secrets changed outside of the operator: "+strings.Join(drift, ", "), lastUpdated(sources), reconciled)
 	}
 
 	k8sSecret := &corev1.Secret{}
 	switch opsecret.Spec.Secret.SecretType {
 	case "docker":
-		k8sSecret, err = o.getDockerSecret(opsecret, section)
+		k8sSecret, err = o.getDockerSecret(opsecret, sources[0].section)
 		if err != nil {
-			return ctrl.Result{}, err
+			theLog.Error("unable to build docker secret from 1Password data", "error", err.Error())
+			setSyncFailed(opsecret, err)
+			return o.requeueFailure(ctx, object, opsecret, k8sClient, recorder, theLog, err)
+		}
+	case "tls":
+		k8sSecret, err = o.getTLSSecret(opsecret, sources)
+		if err != nil {
+			theLog.Error("unable to build tls secret from 1Password data", "error", err.Error())
+			setSyncFailed(opsecret, err)
+			return o.requeueFailure(ctx, object, opsecret, k8sClient, recorder, theLog, err)
+		}
+	case "ssh", "basic-auth":
+		k8sSecret, err = o.getTypedSecret(opsecret, sources)
+		if err != nil {
+			theLog.Error("unable to build secret from 1Password data", "error", err.Error())
+			setSyncFailed(opsecret, err)
+			return o.requeueFailure(ctx, object, opsecret, k8sClient, recorder, theLog, err)
 		}
 	default:
-		k8sSecret = &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: opsecret.Spec.Secret.Name,
-			},
-			Type:       corev1.SecretTypeOpaque,
-			StringData: make(map[string]string),
-		}
-
-		for _, key := range opsecret.Spec.Secret.Keys {
-			found, ok := section.Values[key.From]
-			if !ok {
-				foundAsFile, ok := section.Files[key.From]
-				if !ok {
-					o.log.Error("could not find matching key in section", "key", key.From)
-					return ctrl.Result{}, nil
-				}
-				fileContent, err := o.client.FileContent(foundAsFile)
-				if err != nil {
-					o.log.Error("error trying to retrieve contents of file", "file", foundAsFile.Name, "error", err.Error())
-					return ctrl.Result{}, nil
-				}
-				k8sSecret.StringData[key.To] = string(fileContent)
-			} else {
-				k8sSecret.StringData[key.To] = found.Value
-			}
+		k8sSecret, err = o.getBasicSecret(opsecret, sources)
+		if err != nil {
+			theLog.Error("unable to build secret from 1Password data", "error", err.Error())
+			setSyncFailed(opsecret, err)
+			return o.requeueFailure(ctx, object, opsecret, k8sClient, recorder, theLog, err)
 		}
 	}
 
-	// Check if the opsecret already exists
-	for _, namespace := range opsecret.Spec.Secret.Namespaces {
-		k8sSecret.Namespace = namespace
-		existingSecret := &corev1.Secret{}
-		err = k8sClient.Get(ctx, types.NamespacedName{Name: k8sSecret.Name, Namespace: namespace}, existingSecret)
-		if err != nil && apierrors.IsNotFound(err) {
-			err = k8sClient.Create(ctx, k8sSecret)
-			if err != nil {
-				if err.Error() != errorToSuppress {
-					theLog.Error(fmt.Sprintf("error creating secret : %s/%s", namespace, opsecret.Spec.Secret.Name), "error", err.Error(),
-						"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
-				}
-				return ctrl.Result{}, err
-			}
-			theLog.Info(fmt.Sprintf("created secret : %s/%s", namespace, opsecret.Spec.Secret.Name),
-				"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
-
-			o.addSecretToStatus(opsecret, k8sSecret)
-			opsecret.Status.Events = append(opsecret.Status.Events, crds.Event{
-				Timestamp:   metav1.Now(),
-				OpTimestamp: metav1.NewTime(section.LastUpdated),
-				Type:        "create",
-				Message:     "Secret created from 1Password data",
-			})
-
-			deleted, err := o.deleteDependentPods(ctx, opsecret, k8sClient)
-			if err != nil {
-				theLog.Error("error deleting dependent pods", "error", err.Error(),
-					"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
+	kind := outputKind(opsecret.Spec.Secret.OutputKind)
+	opsecret.Status.ContentHash = contentHash(k8sSecret)
+	output, err := toOutput(opsecret, k8sSecret)
+	if err != nil {
+		theLog.Error("unable to build output from 1Password data", "error", err.Error())
+		setSyncFailed(opsecret, err)
+		return o.requeueFailure(ctx, object, opsecret, k8sClient, recorder, theLog, err)
+	}
+
+	conflicts, missing := make([]string, 0), make([]string, 0)
+	for _, namespace := range namespaces {
+		desired := output.DeepCopyObject().(client.Object)
+		desired.SetNamespace(namespace)
+		location := fmt.Sprintf("%s/%s", namespace, desired.GetName())
+		// Owner references cannot cross namespaces, but a cluster scoped owner can own objects in any namespace
+		if namespace == opsecret.Namespace || opsecret.Namespace == "" {
+			if err = controllerutil.SetControllerReference(object, desired, scheme); err != nil {
+				theLog.Error("unable to set owner reference", "error", err.Error(), "secret.location", location)
 				return ctrl.Result{}, err
 			}
-			for _, deletedPod := range deleted {
-				theLog.Info("deleted pod due to secret creation", "pod", deletedPod,
-					"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
-			}
-		} else if err == nil {
-			if reflect.DeepEqual(existingSecret.StringData, k8sSecret.StringData) {
-				o.addSecretToStatus(opsecret, k8sSecret)
-				continue
-			}
-			existingSecret.StringData = k8sSecret.StringData
-			err = k8sClient.Update(ctx, existingSecret)
-			if err != nil {
-				theLog.Error("failed to update secret", "error", err.Error(),
-					"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
-				return ctrl.Result{}, err
+		}
+
+		// Check if the secret already exists
+		existingSecret := newOutput(kind)
+		exists := true
+		err = k8sClient.Get(ctx, types.NamespacedName{Name: desired.GetName(), Namespace: namespace}, existingSecret)
+		if apierrors.IsNotFound(err) {
+			exists = false
+		} else if err != nil {
+			theLog.Error("error checking for existing secret", "error", err.Error(), "secret.location", location)
+			return ctrl.Result{}, err
+		}
+
+		if exists {
+			if !ownsChild(opsecret, kind, existingSecret) {
+				if !canAdopt(opsecret, existingSecret) {
+					theLog.Error("refusing to overwrite "+describeOutput(kind, namespace, desired.GetName())+" as it was not created by this opsecret",
+						"secret.location", location)
+					conflicts = append(conflicts, location)
+					continue
+				}
+				theLog.Info("adopting "+describeOutput(kind, namespace, desired.GetName()), "secret.location", location)
 			}
-			theLog.Info(fmt.Sprintf("updated secret : %s/%s", namespace, opsecret.Spec.Secret.Name),
-				"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
-
-			o.addSecretToStatus(opsecret, k8sSecret)
-			opsecret.Status.Events = append(opsecret.Status.Events, crds.Event{
-				Timestamp:   metav1.Now(),
-				OpTimestamp: metav1.NewTime(section.LastUpdated),
-				Type:        "update",
-				Message:     "secret has been updated to reflect changes in 1Password",
-			})
-
-			deleted, err := o.deleteDependentPods(ctx, opsecret, k8sClient)
-			if err != nil {
-				theLog.Error("error deleting dependent pods", "error", err.Error())
+			if err = takeOverFields(ctx, existingSecret, k8sClient); err != nil {
+				theLog.Error("unable to take over the fields of "+describeOutput(kind, namespace, desired.GetName()), "error", err.Error(),
+					"secret.location", location)
 				return ctrl.Result{}, err
 			}
-			for _, deletedPod := range deleted {
-				theLog.Info("deleted pod due to secret update", "pod", deletedPod,
-					"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
-			}
+		}
+
+		// Applying is always done, as it is idempotent and removes labels and annotations no longer in the spec, but only
+		// a change to the data is reported and restarts pods
+		err = applyOutput(ctx, desired, k8sClient)
+		if apierrors.IsNotFound(err) {
+			theLog.Error("namespace does not exist for "+describeOutput(kind, namespace, desired.GetName()), "error", err.Error(),
+				"secret.location", location)
+			missing = append(missing, namespace)
+			continue
+		}
+		if err != nil {
+			theLog.Error("error applying "+describeOutput(kind, namespace, desired.GetName()), "error", err.Error(),
+				"secret.location", location)
+			return ctrl.Result{}, err
+		}
+		if exists && outputDataEqual(existingSecret, desired) {
+			o.addSecretToStatus(opsecret, kind, desired)
+			continue
+		}
+		o.addSecretToStatus(opsecret, kind, desired)
+
+		cause := "secret creation"
+		if exists {
+			cause = "secret update"
+			theLog.Info("updated "+describeOutput(kind, namespace, desired.GetName()), "secret.location", location)
+			recordEvent(object, opsecret, recorder, eventUpdate,
+				"updated "+describeOutput(kind, namespace, desired.GetName())+" to reflect changes in 1Password", lastUpdated(sources), reconciled)
 		} else {
-			theLog.Error("error checking for existing secret", "error", err.Error(),
-				"secret.location", fmt.Sprintf("%s/%s", namespace, opsecret.Spec.Secret.Name))
+			theLog.Info("created "+describeOutput(kind, namespace, desired.GetName()), "secret.location", location)
+			recordEvent(object, opsecret, recorder, eventCreate,
+				"created "+describeOutput(kind, namespace, desired.GetName())+" from 1Password data", lastUpdated(sources), reconciled)
+		}
+
+		// Pods reference an immutable object by its hashed name, so until they are changed to use the new object there
+		// are none to restart
+		restarted, err := o.restartDependentPods(ctx, opsecret, namespace, desired.GetName(), k8sClient)
+		if err != nil {
+			theLog.Error("error restarting dependent pods", "error", err.Error(), "secret.location", location)
 			return ctrl.Result{}, err
 		}
+		for _, restartedWorkload := range restarted {
+			theLog.Info("restarted workload due to "+cause, "workload", restartedWorkload, "secret.location", location)
+		}
 	}
 
 	for _, secret := range opsecret.Status.Secrets {
-		if !o.shouldBeDeleted(opsecret, &secret) {
+		if !o.shouldBeDeleted(opsecret, namespaces, &secret) {
 			continue
 		}
-		existingSecret := &corev1.Secret{}
-		if err = k8sClient.Get(ctx, types.NamespacedName{Name: k8sSecret.Name, Namespace: secret.Namespace}, existingSecret); err != nil {
+		if !namespaceAllowed(opsecret, secret.Namespace) {
+			// Secrets created before cross namespace secrets were disallowed are left in place, so that disallowing them
+			// never removes a secret which is in use. The opsecret is degraded until they are moved to a ClusterOpSecret
+			if !slices.Contains(denied, secret.Namespace) {
+				denied = append(denied, secret.Namespace)
+			}
+			continue
+		}
+		if o.isSuperseded(opsecret, namespaces, &secret) {
+			// A replaced immutable object is kept until no pod references it, once they have been changed to use its
+			// replacement
+			referenced, err := o.isReferenced(ctx, secret.Kind, secret.Namespace, secret.Name)
+			if err != nil {
+				theLog.Error("error checking whether pods use secret", "error", err.Error(),
+					"secret", fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
+				continue
+			}
+			if referenced {
+				continue
+			}
+		}
+		existingSecret := newOutput(secret.Kind)
+		err = k8sClient.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, existingSecret)
+		if apierrors.IsNotFound(err) {
+			// Already gone, for example along with its namespace
+			o.updateOpsecretPostDeletion(opsecret, &secret)
+			continue
+		}
+		if err != nil {
 			theLog.Error("error getting secret for deletion", "error", err.Error(),
 				"secret", fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
 			continue
@@ -240,7 +332,7 @@ func (o operator) Reconcile(ctx context.Context, req ctrl.Request, k8sClient cli
 				"secret", fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
 			continue
 		}
-		theLog.Info(fmt.Sprintf("deleted secret : %s/%s", existingSecret.Namespace, existingSecret.Name), "cause", "deleted from opsecret spec")
+		theLog.Info("deleted "+describeOutput(secret.Kind, existingSecret.GetNamespace(), existingSecret.GetName()), "cause", "deleted from opsecret spec")
 		o.updateOpsecretPostDeletion(opsecret, &secret)
 	}
 
@@ -248,8 +340,11 @@ func (o operator) Reconcile(ctx context.Context, req ctrl.Request, k8sClient cli
 		opsecret.Status.Events = []crds.Event{}
 	}
 
+	finishEvents(opsecret)
+	setSynced(opsecret, namespaces, denied, missing, conflicts)
+	setSourcesStatus(opsecret, sources)
 	opsecret.Status.LastReconciled = metav1.NewTime(time.Now())
-	err = k8sClient.Status().Update(ctx, opsecret)
+	err = k8sClient.Status().Update(ctx, object)
 	if err != nil {
 		theLog.Error("failed to update the last reconciled time for opsecret", "error", err.Error())
 		return ctrl.Result{}, err
@@ -258,10 +353,33 @@ func (o operator) Reconcile(ctx context.Context, req ctrl.Request, k8sClient cli
 	return o.getRequeue(opsecret), nil
 }
 
+// recordFailure records why reconciling stopped early as an event and writes the opsecret's status, so its conditions
+// explain the failure
+func (o operator) recordFailure(ctx context.Context, object client.Object, opsecret *crds.OpSecret, k8sClient client.Client,
+	recorder record.EventRecorder, theLog log.Log, failure error) error {
+	recordEvent(object, opsecret, recorder, eventError, failure.Error(), time.Time{}, time.Now())
+	finishEvents(opsecret)
+	if err := k8sClient.Status().Update(ctx, object); err != nil {
+		theLog.Error("failed to update the status of opsecret", "error", err.Error())
+		return err
+	}
+	return nil
+}
+
+// requeueFailure records a failure to build the objects from the 1Password data and requeues the opsecret for its next
+// refresh, as retrying sooner would read the same data
+func (o operator) requeueFailure(ctx context.Context, object client.Object, opsecret *crds.OpSecret, k8sClient client.Client,
+	recorder record.EventRecorder, theLog log.Log, failure error) (ctrl.Result, error) {
+	if err := o.recordFailure(ctx, object, opsecret, k8sClient, recorder, theLog, failure); err != nil {
+		return ctrl.Result{}, err
+	}
+	return o.getRequeue(opsecret), nil
+}
+
 func (o operator) updateOpsecretPostDeletion(opsecret *crds.OpSecret, secret *crds.Secret) {
 	newSecrets := make([]crds.Secret, 0)
 	for _, theSecret := range opsecret.Status.Secrets {
-		if theSecret.Name == secret.Name && theSecret.Namespace == secret.Namespace {
+		if theSecret.Name == secret.Name && theSecret.Namespace == secret.Namespace && outputKind(theSecret.Kind) == outputKind(secret.Kind) {
 			continue
 		}
 		newSecrets = append(newSecrets, theSecret)
@@ -269,11 +387,11 @@ func (o operator) updateOpsecretPostDeletion(opsecret *crds.OpSecret, secret *cr
 	opsecret.Status.Secrets = newSecrets
 }
 
-func (o operator) shouldBeDeleted(opsecret *crds.OpSecret, secret *crds.Secret) bool {
-	if secret.Name != opsecret.Spec.Secret.Name {
+func (o operator) shouldBeDeleted(opsecret *crds.OpSecret, namespaces []string, secret *crds.Secret) bool {
+	if secret.Name != childName(opsecret) || outputKind(secret.Kind) != outputKind(opsecret.Spec.Secret.OutputKind) {
 		return true
 	}
-	for _, ns := range opsecret.Spec.Secret.Namespaces {
+	for _, ns := range namespaces {
 		if ns == secret.Namespace {
 			return false
 		}
@@ -281,69 +399,74 @@ func (o operator) shouldBeDeleted(opsecret *crds.OpSecret, secret *crds.Secret)
 	return true
 }
 
-func (o operator) addSecretToStatus(opsecret *crds.OpSecret, secret *corev1.Secret) {
+// isSuperseded reports whether a listed object is an immutable object which has been replaced by one with new content,
+// in a namespace the opsecret still writes to
+func (o operator) isSuperseded(opsecret *crds.OpSecret, namespaces []string, secret *crds.Secret) bool {
+	return opsecret.Spec.Secret.Immutable && secret.Name != childName(opsecret) &&
+		outputKind(secret.Kind) == outputKind(opsecret.Spec.Secret.OutputKind) && slices.Contains(namespaces, secret.Namespace)
+}
+
+func (o operator) addSecretToStatus(opsecret *crds.OpSecret, kind string, secret client.Object) {
 	if len(opsecret.Status.Secrets) < 1 {
 		opsecret.Status.Secrets = make([]crds.Secret, 0)
 	}
 	for _, childSecret := range opsecret.Status.Secrets {
-		if childSecret.Namespace == secret.Namespace && childSecret.Name == secret.Name {
+		if childSecret.Namespace == secret.GetNamespace() && childSecret.Name == secret.GetName() && outputKind(childSecret.Kind) == kind {
 			return
 		}
 	}
 	opsecret.Status.Secrets = append(opsecret.Status.Secrets, crds.Secret{
-		Name:      secret.Name,
-		Namespace: secret.Namespace,
+		Kind:      kind,
+		Name:      secret.GetName(),
+		Namespace: secret.GetNamespace(),
 	})
 }
 
-func (o operator) deleteDependentPods(ctx context.Context, opsecret *crds.OpSecret, k8sClient client.Client) ([]string, error) {
-	podList := &corev1.PodList{}
-	err := k8sClient.List(ctx, podList)
-	if err != nil {
-		return nil, fmt.Errorf("could not list pods : %w", err)
-	}
-
-	deletedPods := make([]string, 0)
-	for _, pod := range podList.Items {
-		if isPodUsingSecret(&pod, opsecret.Spec.Secret.Name) {
-			err = k8sClient.Delete(ctx, &pod)
-			if err != nil {
-				return nil, fmt.Errorf("could not delete pod : %w", err)
-			} else {
-				deletedPods = append(deletedPods, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
-			}
-		}
-	}
-	return deletedPods, nil
-}
-
-func (o operator) updateRequired(opsecret *crds.OpSecret, section onepassword.Section) bool {
+func (o operator) updateRequired(ctx context.Context, opsecret *crds.OpSecret, namespaces []string, sources []source) bool {
 	foundSecrets := 0
-	for _, namespace := range opsecret.Spec.Secret.Namespaces {
+	for _, namespace := range namespaces {
 		for _, secret := range opsecret.Status.Secrets {
-			if secret.Name == opsecret.Spec.Secret.Name && secret.Namespace == namespace {
+			if secret.Name == childName(opsecret) && secret.Namespace == namespace && !o.shouldBeDeleted(opsecret, namespaces, &secret) {
 				foundSecrets++
 			}
 		}
 	}
-	if foundSecrets < len(opsecret.Spec.Secret.Namespaces) {
+	if foundSecrets < len(namespaces) {
 		return true
 	}
 
-	if opsecret.Status.LastReconciled.Time.Before(section.LastUpdated) || opsecret.Status.LastReconciled.Time.Before(opsecret.Spec.LastUpdated.Time) {
+	ready := meta.FindStatusCondition(opsecret.Status.Conditions, conditionReady)
+	if ready == nil || ready.ObservedGeneration != opsecret.Generation {
+		return true
+	}
+	// A failed or partial sync is retried on every refresh, even if nothing has changed since
+	if ready.Status != metav1.ConditionTrue || !meta.IsStatusConditionTrue(opsecret.Status.Conditions, conditionSynced) {
 		return true
 	}
 
-	for _, keyMapping := range opsecret.Spec.Secret.Keys {
-		if opsecret.Status.LastReconciled.Time.Before(section.Values[keyMapping.From].LastUpdated) {
-			return true
+	if sourcesUpdated(opsecret, sources) || opsecret.Status.LastReconciled.Time.Before(opsecret.Spec.LastUpdated.Time) {
+		return true
+	}
+
+	for _, s := range sources {
+		for _, keyMapping := range s.keys {
+			if s.section.Values[keyMapping.From].LastUpdated.Truncate(time.Second).After(opsecret.Status.LastReconciled.Time) {
+				return true
+			}
 		}
 	}
 
 	for _, secret := range opsecret.Status.Secrets {
-		if o.shouldBeDeleted(opsecret, &secret) {
-			return true
+		if !o.shouldBeDeleted(opsecret, namespaces, &secret) {
+			continue
 		}
+		// A replaced immutable object still referenced by pods is kept, so does not need reconciling until it is unused
+		if o.isSuperseded(opsecret, namespaces, &secret) {
+			if referenced, err := o.isReferenced(ctx, secret.Kind, secret.Namespace, secret.Name); err == nil && referenced {
+				continue
+			}
+		}
+		return true
 	}
 	return false
 }
@@ -355,85 +478,55 @@ func (o operator) getRequeue(opsecret *crds.OpSecret) ctrl.Result {
 	return ctrl.Result{RequeueAfter: time.Second * time.Duration(conf.Config.Secrets.Refresh.MinIntervalSeconds)}
 }
 
-func (o operator) getDockerSecret(opsecret *crds.OpSecret, section onepassword.Section) (*corev1.Secret, error) {
-	if opsecret.Spec.Secret.SecretType != "docker" {
-		return nil, errors.New("wrong secret type: " + opsecret.Spec.Secret.SecretType)
-	}
-
-	if len(opsecret.Spec.Secret.Keys) < 1 {
-		return nil, errors.New("no keys defined")
+// getBasicSecret merges the data from every source into an opaque secret. All keys and templates are read from the
+// first source; a key produced by more than one source is a conflict and is rejected
+func (o operator) getBasicSecret(opsecret *crds.OpSecret, sources []source) (*corev1.Secret, error) {
+	k8sSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName(opsecret),
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: make(map[string]string),
 	}
 
-	file, ok := section.Files[opsecret.Spec.Secret.Keys[0].From]
-	if !ok {
-		return nil, fmt.Errorf("specified soure file does not exist in section: %s", opsecret.Spec.Secret.Keys[0])
+	primary := sources[0]
+	if opsecret.Spec.Secret.AllKeys {
+		all, err := o.getAllKeys(opsecret, primary.section)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range all {
+			k8sSecret.StringData[key] = value
+		}
 	}
 
-	tokenData, err := o.client.FileContent(file)
+	mapped, err := o.getMappedKeys(primary.keys, primary.section)
 	if err != nil {
-		return nil, errors.New("missing file content: token.json")
+		return nil, err
 	}
-
-	dockerConfig := map[string]interface{}{
-		"auths": map[string]map[string]string{
-			opsecret.Spec.Secret.Keys[0].To: {
-				"username": "_json_key",
-				"password": string(tokenData),
-				"email":    "test@jdd.email",
-			},
-		},
+	for key, value := range mapped {
+		k8sSecret.StringData[key] = value
 	}
-	dockerConfigJson, err := json.Marshal(dockerConfig)
+
+	rendered, err := o.renderTemplates(opsecret, primary.section)
 	if err != nil {
 		return nil, err
 	}
-
-	// Create the Secret
-	return &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: opsecret.Spec.Secret.Name,
-		},
-		Type: corev1.SecretTypeDockerConfigJson,
-		Data: map[string][]byte{
-			".dockerconfigjson": dockerConfigJson,
-		},
-	}, nil
-}
-
-func isPodUsingSecret(pod *corev1.Pod, secretName string) bool {
-	bits := strings.Split(pod.Name, "-")
-	if len(bits) > 2 && bits[0] == "operator" && bits[1] == "opsecrets" {
-		return false
+	for key, value := range rendered {
+		k8sSecret.StringData[key] = value
 	}
 
-	for _, pullSecret := range pod.Spec.ImagePullSecrets {
-		if pullSecret.Name == secretName {
-			return true
-		}
-	}
-
-	for _, container := range pod.Spec.Containers {
-		// Check envFrom
-		for _, envFrom := range container.EnvFrom {
-			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == secretName {
-				return true
-			}
+	for _, additional := range sources[1:] {
+		mapped, err = o.getMappedKeys(additional.keys, additional.section)
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", additional, err)
 		}
-
-		// Check individual env variables
-		for _, envVar := range container.Env {
-			if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil && envVar.ValueFrom.SecretKeyRef.Name == secretName {
-				return true
+		for key, value := range mapped {
+			if _, exists := k8sSecret.StringData[key]; exists {
+				return nil, fmt.Errorf("key %s from source %s conflicts with a key from another source", key, additional)
 			}
+			k8sSecret.StringData[key] = value
 		}
 	}
-
-	// Check if the secret is mounted as a volume
-	for _, volume := range pod.Spec.Volumes {
-		if volume.Secret != nil && volume.Secret.SecretName == secretName {
-			return true
-		}
-	}
-
-	return false
+	return k8sSecret, nil
 }
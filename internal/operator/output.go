@@ -0,0 +1,183 @@
+package operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	"maps"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+)
+
+const (
+	// contentHashLength is the number of characters of the content hash used in the names of immutable objects
+	contentHashLength = 10
+	// contentHashAnnotation records the hash of the data an object was written with, so changes made to it can be detected
+	contentHashAnnotation = "opsecrets.crds.driscoll.co/content-hash"
+	// sourceAnnotation records the opsecret an object was created from as namespace/name, or the name of a ClusterOpSecret
+	sourceAnnotation = "opsecrets.crds.driscoll.co/source"
+)
+
+// outputKind returns the kind of object the opsecret produces, defaulting to a Secret
+func outputKind(kind string) string {
+	if kind == "" {
+		return crds.OutputKindSecret
+	}
+	return kind
+}
+
+// newOutput returns an empty object of the given output kind
+func newOutput(kind string) client.Object {
+	if outputKind(kind) == crds.OutputKindConfigMap {
+		return &corev1.ConfigMap{}
+	}
+	return &corev1.Secret{}
+}
+
+// secretName returns the name given to the objects the opsecret creates, defaulting to the opsecret's own name
+func secretName(opsecret *crds.OpSecret) string {
+	if opsecret.Spec.Secret.Name == "" {
+		return opsecret.Name
+	}
+	return opsecret.Spec.Secret.Name
+}
+
+// childName returns the name of the objects the opsecret creates. Immutable objects are named after a hash of their
+// content, so that a change in 1Password creates a new object rather than updating the existing one
+func childName(opsecret *crds.OpSecret) string {
+	if !opsecret.Spec.Secret.Immutable || len(opsecret.Status.ContentHash) < contentHashLength {
+		return secretName(opsecret)
+	}
+	return fmt.Sprintf("%s-%s", secretName(opsecret), opsecret.Status.ContentHash[:contentHashLength])
+}
+
+// outputData returns the data held by a secret or config map, whether set as strings or bytes
+func outputData(object client.Object) map[string][]byte {
+	data := make(map[string][]byte)
+	switch o := object.(type) {
+	case *corev1.ConfigMap:
+		for key, value := range o.Data {
+			data[key] = []byte(value)
+		}
+		maps.Copy(data, o.BinaryData)
+	case *corev1.Secret:
+		maps.Copy(data, o.Data)
+		for key, value := range o.StringData {
+			data[key] = []byte(value)
+		}
+	}
+	return data
+}
+
+// contentHash returns a hash of the data held by a secret or config map
+func contentHash(object client.Object) string {
+	data := outputData(object)
+	hash := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(data[key])
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// drifted reports whether the data in an object created from an opsecret no longer matches the content hash it was
+// written with
+func drifted(child client.Object) bool {
+	written, ok := child.GetAnnotations()[contentHashAnnotation]
+	return ok && written != contentHash(child)
+}
+
+// toOutput turns the secret built from 1Password into the kind of object the opsecret should produce, with the
+// opsecret's name, labels, annotations and immutability applied
+func toOutput(opsecret *crds.OpSecret, k8sSecret *corev1.Secret) (client.Object, error) {
+	k8sSecret = k8sSecret.DeepCopy()
+	k8sSecret.Name = childName(opsecret)
+	k8sSecret.Labels = maps.Clone(opsecret.Spec.Secret.Labels)
+	if k8sSecret.Labels == nil {
+		k8sSecret.Labels = make(map[string]string)
+	}
+	maps.Copy(k8sSecret.Labels, childLabels(opsecret))
+	k8sSecret.Annotations = maps.Clone(opsecret.Spec.Secret.Annotations)
+	if k8sSecret.Annotations == nil {
+		k8sSecret.Annotations = make(map[string]string)
+	}
+	k8sSecret.Annotations[sourceAnnotation] = opsecretLocation(opsecret)
+	k8sSecret.Annotations[contentHashAnnotation] = contentHash(k8sSecret)
+	if opsecret.Spec.Secret.Immutable {
+		immutable := true
+		k8sSecret.Immutable = &immutable
+	}
+
+	if outputKind(opsecret.Spec.Secret.OutputKind) != crds.OutputKindConfigMap {
+		// Server side apply tracks data rather than the write-only stringData
+		k8sSecret.Data = outputData(k8sSecret)
+		k8sSecret.StringData = nil
+		return k8sSecret, nil
+	}
+	if k8sSecret.Type != corev1.SecretTypeOpaque {
+		return nil, fmt.Errorf("%s secrets cannot be written to a ConfigMap", opsecret.Spec.Secret.SecretType)
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: k8sSecret.ObjectMeta,
+		Data:       k8sSecret.StringData,
+		Immutable:  k8sSecret.Immutable,
+	}
+	if len(k8sSecret.Data) > 0 {
+		configMap.BinaryData = k8sSecret.Data
+	}
+	return configMap, nil
+}
+
+// outputDataEqual reports whether two objects of the same output kind hold the same data
+func outputDataEqual(existing, desired client.Object) bool {
+	return contentHash(existing) == contentHash(desired)
+}
+
+// applyOutput creates or updates an object with server side apply, so fields set by other controllers are left alone
+// and only fields the operator previously set but no longer wants are removed
+func applyOutput(ctx context.Context, desired client.Object, k8sClient client.Client) error {
+	desired = desired.DeepCopyObject().(client.Object)
+	desired.SetResourceVersion("")
+	desired.SetManagedFields(nil)
+	desired.GetObjectKind().SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kindOf(desired)))
+	return k8sClient.Patch(ctx, desired, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// takeOverFields gives the operator's field manager ownership of the fields of an existing object which were written by
+// updates rather than server side apply, such as by earlier versions of the operator or by whatever created an adopted
+// object. Server side apply only removes the fields its field manager owns, so without this, data keys the operator no
+// longer writes would never be removed. Objects the operator has already applied are left alone
+func takeOverFields(ctx context.Context, existing client.Object, k8sClient client.Client) error {
+	managers := sets.New[string]()
+	for _, entry := range existing.GetManagedFields() {
+		if entry.Manager == fieldManager && entry.Operation == metav1.ManagedFieldsOperationApply {
+			return nil
+		}
+		if entry.Operation == metav1.ManagedFieldsOperationUpdate && entry.Subresource == "" {
+			managers.Insert(entry.Manager)
+		}
+	}
+	if managers.Len() < 1 {
+		return nil
+	}
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(existing, managers, fieldManager)
+	if err != nil || patch == nil {
+		return err
+	}
+	return k8sClient.Patch(ctx, existing, client.RawPatch(types.JSONPatchType, patch))
+}
+
+// describeOutput names an output object for log lines, e.g. "secret : namespace/name"
+func describeOutput(kind, namespace, name string) string {
+	return fmt.Sprintf("%s : %s/%s", strings.ToLower(outputKind(kind)), namespace, name)
+}
@@ -0,0 +1,216 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/conf"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+)
+
+const (
+	// podSecretIndex indexes pods by the names of the secrets they reference
+	podSecretIndex = "spec.secretNames"
+	// podConfigMapIndex indexes pods by the names of the config maps they reference
+	podConfigMapIndex = "spec.configMapNames"
+	// ignoreLabel can be set to "true" on a pod to stop the operator ever restarting it
+	ignoreLabel = "opsecrets.crds.driscoll.co/ignore"
+)
+
+// NewCache returns a cache of pods indexed by the secrets and config maps they reference, so finding the pods using
+// one does not require scanning every pod in the cluster. Secrets and config maps are only cached if they were created
+// by the operator. Namespaces are also cached, to evaluate namespace selectors. The cache must be started before it is
+// used.
+func NewCache(ctx context.Context, config *rest.Config) (cache.Cache, error) {
+	managed := labels.SelectorFromSet(labels.Set{managedByLabel: managedBy})
+	podCache, err := cache.New(config, cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}:    {Label: managed},
+			&corev1.ConfigMap{}: {Label: managed},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = podCache.IndexField(ctx, &corev1.Pod{}, podSecretIndex, func(obj client.Object) []string {
+		return podSecretNames(obj.(*corev1.Pod))
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = podCache.IndexField(ctx, &corev1.Pod{}, podConfigMapIndex, func(obj client.Object) []string {
+		return podConfigMapNames(obj.(*corev1.Pod))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return podCache, nil
+}
+
+// podSecretNames returns the name of every secret a pod references
+func podSecretNames(pod *corev1.Pod) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	for _, pullSecret := range pod.Spec.ImagePullSecrets {
+		add(pullSecret.Name)
+	}
+
+	addEnv := func(envFrom []corev1.EnvFromSource, env []corev1.EnvVar) {
+		for _, source := range envFrom {
+			if source.SecretRef != nil {
+				add(source.SecretRef.Name)
+			}
+		}
+		for _, envVar := range env {
+			if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil {
+				add(envVar.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+	for _, container := range pod.Spec.Containers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.Secret != nil:
+			add(volume.Secret.SecretName)
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					add(source.Secret.Name)
+				}
+			}
+		case volume.CSI != nil && volume.CSI.NodePublishSecretRef != nil:
+			add(volume.CSI.NodePublishSecretRef.Name)
+		case volume.AzureFile != nil:
+			add(volume.AzureFile.SecretName)
+		case volume.CephFS != nil && volume.CephFS.SecretRef != nil:
+			add(volume.CephFS.SecretRef.Name)
+		case volume.Cinder != nil && volume.Cinder.SecretRef != nil:
+			add(volume.Cinder.SecretRef.Name)
+		case volume.FlexVolume != nil && volume.FlexVolume.SecretRef != nil:
+			add(volume.FlexVolume.SecretRef.Name)
+		case volume.ISCSI != nil && volume.ISCSI.SecretRef != nil:
+			add(volume.ISCSI.SecretRef.Name)
+		case volume.RBD != nil && volume.RBD.SecretRef != nil:
+			add(volume.RBD.SecretRef.Name)
+		case volume.ScaleIO != nil && volume.ScaleIO.SecretRef != nil:
+			add(volume.ScaleIO.SecretRef.Name)
+		case volume.StorageOS != nil && volume.StorageOS.SecretRef != nil:
+			add(volume.StorageOS.SecretRef.Name)
+		}
+	}
+
+	return names
+}
+
+// isIgnoredPod reports whether a pod is the operator itself or has been labelled to be left alone
+func isIgnoredPod(pod *corev1.Pod) bool {
+	if pod.Name == conf.Config.Operator.Pod.Name && pod.Namespace == conf.Config.Operator.Pod.Namespace {
+		return true
+	}
+	ignore, err := strconv.ParseBool(pod.Labels[ignoreLabel])
+	return err == nil && ignore
+}
+
+// podConfigMapNames returns the name of every config map a pod references
+func podConfigMapNames(pod *corev1.Pod) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	addEnv := func(envFrom []corev1.EnvFromSource, env []corev1.EnvVar) {
+		for _, source := range envFrom {
+			if source.ConfigMapRef != nil {
+				add(source.ConfigMapRef.Name)
+			}
+		}
+		for _, envVar := range env {
+			if envVar.ValueFrom != nil && envVar.ValueFrom.ConfigMapKeyRef != nil {
+				add(envVar.ValueFrom.ConfigMapKeyRef.Name)
+			}
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+	for _, container := range pod.Spec.Containers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		addEnv(container.EnvFrom, container.Env)
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.ConfigMap != nil:
+			add(volume.ConfigMap.Name)
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					add(source.ConfigMap.Name)
+				}
+			}
+		}
+	}
+
+	return names
+}
+
+// isReferenced reports whether any pod in the namespace, ignored or not, references the named secret or config map
+func (o operator) isReferenced(ctx context.Context, kind, namespace, name string) (bool, error) {
+	index := podSecretIndex
+	if outputKind(kind) == crds.OutputKindConfigMap {
+		index = podConfigMapIndex
+	}
+	podList := &corev1.PodList{}
+	err := o.cached.List(ctx, podList, client.InNamespace(namespace), client.MatchingFields{index: name})
+	if err != nil {
+		return false, fmt.Errorf("could not list pods : %w", err)
+	}
+	return len(podList.Items) > 0, nil
+}
+
+// isPodUsing reports whether a pod which is not ignored references the named secret or config map
+func isPodUsing(pod *corev1.Pod, kind, name string) bool {
+	if isIgnoredPod(pod) {
+		return false
+	}
+
+	names := podSecretNames(pod)
+	if outputKind(kind) == crds.OutputKindConfigMap {
+		names = podConfigMapNames(pod)
+	}
+	for _, referenced := range names {
+		if referenced == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package operator
+
+import (
+	"github.com/driscollco-cluster/operator-1password/internal/conf"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
+	"testing"
+)
+
+func TestPodSecretNames(t *testing.T) {
+	secretRef := func(name string) *corev1.LocalObjectReference {
+		return &corev1.LocalObjectReference{Name: name}
+	}
+	envFrom := []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: *secretRef("env-from")}}}
+	env := []corev1.EnvVar{{Name: "KEY", ValueFrom: &corev1.EnvVarSource{
+		SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: *secretRef("env"), Key: "key"},
+	}}}
+
+	tests := []struct {
+		name string
+		spec corev1.PodSpec
+		want []string
+	}{
+		{
+			name: "no secrets",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			want: []string{},
+		},
+		{
+			name: "image pull secrets",
+			spec: corev1.PodSpec{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}}},
+			want: []string{"registry"},
+		},
+		{
+			name: "containers",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", EnvFrom: envFrom, Env: env}}},
+			want: []string{"env-from", "env"},
+		},
+		{
+			name: "init containers",
+			spec: corev1.PodSpec{InitContainers: []corev1.Container{{Name: "init", EnvFrom: envFrom, Env: env}}},
+			want: []string{"env-from", "env"},
+		},
+		{
+			name: "ephemeral containers",
+			spec: corev1.PodSpec{EphemeralContainers: []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", EnvFrom: envFrom, Env: env},
+			}}},
+			want: []string{"env-from", "env"},
+		},
+		{
+			name: "duplicates are listed once",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Env: env}},
+				Containers:     []corev1.Container{{Name: "app", Env: env}},
+			},
+			want: []string{"env"},
+		},
+		{
+			name: "secret volume",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "v", VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: "secret"},
+			}}}},
+			want: []string{"secret"},
+		},
+		{
+			name: "projected volume",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "v", VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+					{Secret: &corev1.SecretProjection{LocalObjectReference: *secretRef("first")}},
+					{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: *secretRef("config")}},
+					{Secret: &corev1.SecretProjection{LocalObjectReference: *secretRef("second")}},
+				}},
+			}}}},
+			want: []string{"first", "second"},
+		},
+		{
+			name: "csi node publish secret",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "v", VolumeSource: corev1.VolumeSource{
+				CSI: &corev1.CSIVolumeSource{Driver: "driver", NodePublishSecretRef: secretRef("csi")},
+			}}}},
+			want: []string{"csi"},
+		},
+		{
+			name: "csi without a secret",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "v", VolumeSource: corev1.VolumeSource{
+				CSI: &corev1.CSIVolumeSource{Driver: "driver"},
+			}}}},
+			want: []string{},
+		},
+		{
+			name: "secret ref volumes",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "azure", VolumeSource: corev1.VolumeSource{
+					AzureFile: &corev1.AzureFileVolumeSource{SecretName: "azure"},
+				}},
+				{Name: "cephfs", VolumeSource: corev1.VolumeSource{
+					CephFS: &corev1.CephFSVolumeSource{SecretRef: secretRef("cephfs")},
+				}},
+				{Name: "cinder", VolumeSource: corev1.VolumeSource{
+					Cinder: &corev1.CinderVolumeSource{SecretRef: secretRef("cinder")},
+				}},
+				{Name: "flex", VolumeSource: corev1.VolumeSource{
+					FlexVolume: &corev1.FlexVolumeSource{SecretRef: secretRef("flex")},
+				}},
+				{Name: "iscsi", VolumeSource: corev1.VolumeSource{
+					ISCSI: &corev1.ISCSIVolumeSource{SecretRef: secretRef("iscsi")},
+				}},
+				{Name: "rbd", VolumeSource: corev1.VolumeSource{
+					RBD: &corev1.RBDVolumeSource{SecretRef: secretRef("rbd")},
+				}},
+				{Name: "scaleio", VolumeSource: corev1.VolumeSource{
+					ScaleIO: &corev1.ScaleIOVolumeSource{SecretRef: secretRef("scaleio")},
+				}},
+				{Name: "storageos", VolumeSource: corev1.VolumeSource{
+					StorageOS: &corev1.StorageOSVolumeSource{SecretRef: secretRef("storageos")},
+				}},
+			}},
+			want: []string{"azure", "cephfs", "cinder", "flex", "iscsi", "rbd", "scaleio", "storageos"},
+		},
+		{
+			name: "secret ref volumes without a secret",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "cephfs", VolumeSource: corev1.VolumeSource{CephFS: &corev1.CephFSVolumeSource{}}},
+				{Name: "rbd", VolumeSource: corev1.VolumeSource{RBD: &corev1.RBDVolumeSource{}}},
+			}},
+			want: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := podSecretNames(&corev1.Pod{Spec: test.spec})
+			if !slices.Equal(got, test.want) {
+				t.Errorf("podSecretNames() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsPodUsing(t *testing.T) {
+	conf.Config.Operator.Pod.Name = "operator"
+	conf.Config.Operator.Pod.Namespace = "operators"
+	t.Cleanup(func() {
+		conf.Config.Operator.Pod.Name = ""
+		conf.Config.Operator.Pod.Namespace = ""
+	})
+
+	pod := func(namespace, name string, podLabels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: podLabels},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", EnvFrom: []corev1.EnvFromSource{
+					{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "secret"}}},
+					{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+				}}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		kind     string
+		resource string
+		want     bool
+	}{
+		{name: "secret", pod: pod("default", "app", nil), kind: crds.OutputKindSecret, resource: "secret", want: true},
+		{name: "default kind is secret", pod: pod("default", "app", nil), kind: "", resource: "secret", want: true},
+		{name: "other secret", pod: pod("default", "app", nil), kind: crds.OutputKindSecret, resource: "other", want: false},
+		{name: "config map", pod: pod("default", "app", nil), kind: crds.OutputKindConfigMap, resource: "config", want: true},
+		{name: "secret is not a config map", pod: pod("default", "app", nil), kind: crds.OutputKindConfigMap, resource: "secret", want: false},
+		{name: "config map is not a secret", pod: pod("default", "app", nil), kind: crds.OutputKindSecret, resource: "config", want: false},
+		{
+			name: "ignore label", pod: pod("default", "app", map[string]string{ignoreLabel: "true"}),
+			kind: crds.OutputKindSecret, resource: "secret", want: false,
+		},
+		{
+			name: "ignore label set to false", pod: pod("default", "app", map[string]string{ignoreLabel: "false"}),
+			kind: crds.OutputKindSecret, resource: "secret", want: true,
+		},
+		{
+			name: "invalid ignore label", pod: pod("default", "app", map[string]string{ignoreLabel: "maybe"}),
+			kind: crds.OutputKindSecret, resource: "secret", want: true,
+		},
+		{name: "operator pod", pod: pod("operators", "operator", nil), kind: crds.OutputKindSecret, resource: "secret", want: false},
+		{
+			name: "operator pod name in another namespace", pod: pod("default", "operator", nil),
+			kind: crds.OutputKindSecret, resource: "secret", want: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isPodUsing(test.pod, test.kind, test.resource); got != test.want {
+				t.Errorf("isPodUsing() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"time"
+)
+
+const (
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	// restartAnnotation can be set to "false" on a workload or pod to stop the operator restarting it
+	restartAnnotation = "opsecrets.crds.driscoll.co/restart"
+)
+
+// restartDependentPods restarts every pod in the namespace using the named secret or config map according to the
+// opsecret's restart policy.
+// It returns a description of each workload or pod which was restarted.
+func (o operator) restartDependentPods(ctx context.Context, opsecret *crds.OpSecret, namespace, name string, k8sClient client.Client) ([]string, error) {
+	if opsecret.Spec.Secret.RestartPolicy == crds.RestartPolicyNone {
+		return []string{}, nil
+	}
+
+	kind := outputKind(opsecret.Spec.Secret.OutputKind)
+	index := podSecretIndex
+	if kind == crds.OutputKindConfigMap {
+		index = podConfigMapIndex
+	}
+	podList := &corev1.PodList{}
+	err := o.cached.List(ctx, podList, client.InNamespace(namespace), client.MatchingFields{index: name})
+	if err != nil {
+		return nil, fmt.Errorf("could not list pods : %w", err)
+	}
+
+	restarted := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pod := range podList.Items {
+		if !isPodUsing(&pod, kind, name) || restartDisabled(&pod) {
+			continue
+		}
+
+		// The workload is resolved whatever the policy, so a workload which has opted out is never restarted
+		workload, err := getOwningWorkload(ctx, &pod, k8sClient)
+		if err != nil {
+			return nil, fmt.Errorf("could not find workload for pod %s/%s : %w", pod.Namespace, pod.Name, err)
+		}
+		if workload != nil && restartDisabled(workload) {
+			continue
+		}
+
+		owner := metav1.GetControllerOf(&pod)
+		if opsecret.Spec.Secret.RestartPolicy == crds.RestartPolicyDelete || owner == nil {
+			if err = k8sClient.Delete(ctx, &pod); err != nil {
+				return nil, fmt.Errorf("could not delete pod : %w", err)
+			}
+			restarted = append(restarted, fmt.Sprintf("pod/%s/%s", pod.Namespace, pod.Name))
+			continue
+		}
+		if workload == nil {
+			// Controllers such as Jobs cannot be rolled out, and deleting their pods could lose work
+			o.log.Info("not restarting pod as its controller does not support a rolling restart",
+				"pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name),
+				"controller", fmt.Sprintf("%s/%s/%s", owner.APIVersion, owner.Kind, owner.Name),
+				"opsecret.location", opsecretLocation(opsecret))
+			continue
+		}
+
+		kind, _ := podTemplate(workload)
+		description := fmt.Sprintf("%s/%s/%s", kind, workload.GetNamespace(), workload.GetName())
+		if seen[description] {
+			continue
+		}
+		seen[description] = true
+		if err = rolloutRestart(ctx, workload, k8sClient); err != nil {
+			return nil, fmt.Errorf("could not restart %s : %w", description, err)
+		}
+		restarted = append(restarted, description)
+	}
+	return restarted, nil
+}
+
+// getOwningWorkload returns the Deployment, StatefulSet or DaemonSet controlling a pod, or nil if the pod has no
+// controller or is controlled by anything else
+func getOwningWorkload(ctx context.Context, pod *corev1.Pod, k8sClient client.Client) (client.Object, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.APIVersion != appsv1.SchemeGroupVersion.String() {
+		return nil, nil
+	}
+
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}
+	switch owner.Kind {
+	case "ReplicaSet":
+		replicaSet := &appsv1.ReplicaSet{}
+		if err := k8sClient.Get(ctx, key, replicaSet); err != nil {
+			return nil, err
+		}
+		rsOwner := metav1.GetControllerOf(replicaSet)
+		if rsOwner == nil || rsOwner.Kind != "Deployment" || rsOwner.APIVersion != appsv1.SchemeGroupVersion.String() {
+			return nil, nil
+		}
+		deployment := &appsv1.Deployment{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: rsOwner.Name}, deployment); err != nil {
+			return nil, err
+		}
+		return deployment, nil
+	case "StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		if err := k8sClient.Get(ctx, key, statefulSet); err != nil {
+			return nil, err
+		}
+		return statefulSet, nil
+	case "DaemonSet":
+		daemonSet := &appsv1.DaemonSet{}
+		if err := k8sClient.Get(ctx, key, daemonSet); err != nil {
+			return nil, err
+		}
+		return daemonSet, nil
+	}
+	return nil, nil
+}
+
+// rolloutRestart triggers a rolling restart of a workload in the same way as `kubectl rollout restart`
+func rolloutRestart(ctx context.Context, workload client.Object, k8sClient client.Client) error {
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	_, template := podTemplate(workload)
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[restartedAtAnnotation] = time.Now().Format(time.RFC3339)
+	return k8sClient.Patch(ctx, workload, patch)
+}
+
+// restartDisabled reports whether a workload or pod has opted out of being restarted by the operator
+func restartDisabled(object metav1.Object) bool {
+	value, ok := object.GetAnnotations()[restartAnnotation]
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	return err == nil && !enabled
+}
+
+func podTemplate(workload client.Object) (string, *corev1.PodTemplateSpec) {
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		return "deployment", &w.Spec.Template
+	case *appsv1.StatefulSet:
+		return "statefulset", &w.Spec.Template
+	case *appsv1.DaemonSet:
+		return "daemonset", &w.Spec.Template
+	}
+	return "", nil
+}
@@ -0,0 +1,216 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	onepassword "github.com/driscollco-cluster/1password"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"time"
+)
+
+var (
+	errSectionNotFound = errors.New("section was not found")
+	errItemNotFound    = errors.New("item was not found")
+	errApiUnauthorized = errors.New("1Password API rejected the request")
+	errKeyMissing      = errors.New("could not find matching key in section")
+	errFileFetchFailed = errors.New("error trying to retrieve contents of file")
+	errFieldCollision  = errors.New("fields in different sections have the same name")
+)
+
+// source is a location within 1Password along with the data fetched from it
+type source struct {
+	config  crds.SourceConfig
+	keys    []crds.KeyMapping
+	section onepassword.Section
+}
+
+func (s source) String() string {
+	return fmt.Sprintf("%s/%s/%s", s.config.Vault, s.config.Item, s.config.Section)
+}
+
+// getSources fetches every 1Password location the opsecret reads from. The primary source, if set, is always first
+func (o operator) getSources(opsecret *crds.OpSecret) ([]source, error) {
+	sources := make([]source, 0)
+	if opsecret.Spec.Source.Vault != "" {
+		sources = append(sources, source{config: opsecret.Spec.Source, keys: opsecret.Spec.Secret.Keys})
+	}
+	for _, additional := range opsecret.Spec.Sources {
+		sources = append(sources, source{config: additional.SourceConfig, keys: additional.Keys})
+	}
+	if len(sources) < 1 {
+		return nil, errors.New("no source defined")
+	}
+
+	for i := range sources {
+		item, err := o.client.GetItem(sources[i].config.Vault, sources[i].config.Item)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching item %s/%s from 1Password : %w", sources[i].config.Vault, sources[i].config.Item, itemError(err))
+		}
+		if sources[i].config.Section == "" {
+			sources[i].section, err = wholeItemSection(item.Content)
+			if err != nil {
+				return nil, fmt.Errorf("error reading item %s/%s : %w", sources[i].config.Vault, sources[i].config.Item, err)
+			}
+			builtins, err := o.builtins.ItemBuiltins(sources[i].config.Vault, sources[i].config.Item)
+			if err != nil {
+				return nil, fmt.Errorf("error fetching the built in fields of item %s/%s from 1Password : %w",
+					sources[i].config.Vault, sources[i].config.Item, itemError(err))
+			}
+			addBuiltins(&sources[i].section, builtins)
+			continue
+		}
+		section, ok := item.Content[sources[i].config.Section]
+		if !ok {
+			return nil, fmt.Errorf("%w : %s", errSectionNotFound, sources[i])
+		}
+		sources[i].section = section
+	}
+	return sources, nil
+}
+
+// itemError classifies an error from fetching an item. The 1Password client does not return typed errors, so the
+// status code in its message is used
+func itemError(err error) error {
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "401"), strings.Contains(message, "403"), strings.Contains(message, "unauthorized"):
+		return fmt.Errorf("%w : %w", errApiUnauthorized, err)
+	case strings.Contains(message, "404"), strings.Contains(message, "not found"):
+		return fmt.Errorf("%w : %w", errItemNotFound, err)
+	}
+	return err
+}
+
+// wholeItemSection flattens every section of an item into one, so fields can be addressed as section.field.
+// Fields in the item's unnamed default section, such as a login's username and password, keep their plain names.
+// A default section field named like section.field would hide the field in that section, so this is an error
+func wholeItemSection(content map[string]onepassword.Section) (onepassword.Section, error) {
+	merged := onepassword.Section{}
+	var err error
+	for name, section := range content {
+		prefix := ""
+		if name != "" {
+			prefix = name + "."
+		}
+		if merged.Values, err = addPrefixed(merged.Values, section.Values, prefix); err != nil {
+			return onepassword.Section{}, err
+		}
+		if merged.Files, err = addPrefixed(merged.Files, section.Files, prefix); err != nil {
+			return onepassword.Section{}, err
+		}
+		if section.LastUpdated.After(merged.LastUpdated) {
+			merged.LastUpdated = section.LastUpdated
+		}
+	}
+	return merged, nil
+}
+
+// addBuiltins adds an item's built in fields to its whole item section, by their plain names. A field in the item's
+// default section with the same name takes precedence, so items already read by that name are unaffected
+func addBuiltins(section *onepassword.Section, builtins builtinFields) {
+	if section.Values == nil {
+		section.Values = make(map[string]onepassword.Value)
+	}
+	for key, value := range builtins.values {
+		if _, ok := section.Values[key]; ok {
+			continue
+		}
+		section.Values[key] = onepassword.Value{Value: value, LastUpdated: builtins.lastUpdated}
+	}
+	if builtins.lastUpdated.After(section.LastUpdated) {
+		section.LastUpdated = builtins.lastUpdated
+	}
+}
+
+func addPrefixed[V any](to, from map[string]V, prefix string) (map[string]V, error) {
+	if to == nil {
+		to = make(map[string]V)
+	}
+	for key, value := range from {
+		if _, ok := to[prefix+key]; ok {
+			return nil, fmt.Errorf("%w : %s", errFieldCollision, prefix+key)
+		}
+		to[prefix+key] = value
+	}
+	return to, nil
+}
+
+// getMappedKeys copies the values or file contents named by each key mapping out of the section
+func (o operator) getMappedKeys(keys []crds.KeyMapping, section onepassword.Section) (map[string]string, error) {
+	data := make(map[string]string)
+	for _, key := range keys {
+		value, err := o.getKey(key.From, section)
+		if err != nil {
+			return nil, err
+		}
+		data[key.To] = value
+	}
+	return data, nil
+}
+
+// getKey returns the value of a key in the section, or the contents of the file with that name
+func (o operator) getKey(key string, section onepassword.Section) (string, error) {
+	found, ok := section.Values[key]
+	if ok {
+		return found.Value, nil
+	}
+	foundAsFile, ok := section.Files[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", errKeyMissing, key)
+	}
+	fileContent, err := o.client.FileContent(foundAsFile)
+	if err != nil {
+		return "", fmt.Errorf("%w %s : %w", errFileFetchFailed, foundAsFile.Name, err)
+	}
+	return string(fileContent), nil
+}
+
+// sourcesUpdated reports whether any source has changed in 1Password since it was last synced
+func sourcesUpdated(opsecret *crds.OpSecret, sources []source) bool {
+	if len(opsecret.Status.Sources) != len(sources) {
+		return true
+	}
+	for _, s := range sources {
+		status := findSourceStatus(opsecret, s.config)
+		// The status only holds whole seconds, so the 1Password time is truncated before they are compared
+		if status == nil || s.section.LastUpdated.Truncate(time.Second).After(status.LastUpdated.Time) {
+			return true
+		}
+	}
+	return false
+}
+
+func findSourceStatus(opsecret *crds.OpSecret, config crds.SourceConfig) *crds.SourceStatus {
+	for i, status := range opsecret.Status.Sources {
+		if status.Vault == config.Vault && status.Item == config.Item && status.Section == config.Section {
+			return &opsecret.Status.Sources[i]
+		}
+	}
+	return nil
+}
+
+// setSourcesStatus records when each source was last updated in 1Password
+func setSourcesStatus(opsecret *crds.OpSecret, sources []source) {
+	opsecret.Status.Sources = make([]crds.SourceStatus, 0, len(sources))
+	for _, s := range sources {
+		opsecret.Status.Sources = append(opsecret.Status.Sources, crds.SourceStatus{
+			Vault:       s.config.Vault,
+			Item:        s.config.Item,
+			Section:     s.config.Section,
+			LastUpdated: metav1.NewTime(s.section.LastUpdated),
+		})
+	}
+}
+
+// lastUpdated returns the most recent time any of the sources changed in 1Password
+func lastUpdated(sources []source) time.Time {
+	latest := time.Time{}
+	for _, s := range sources {
+		if s.section.LastUpdated.After(latest) {
+			latest = s.section.LastUpdated
+		}
+	}
+	return latest
+}
@@ -0,0 +1,192 @@
+package operator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	onepassword "github.com/driscollco-cluster/1password"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateData is what each secret template is rendered against
+type templateData struct {
+	// Values holds every value in the 1Password section, by key
+	Values map[string]string
+	// Files holds the contents of the files in the 1Password section the templates read, by name
+	Files map[string]string
+}
+
+var templateFuncs = template.FuncMap{
+	"base64": func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	},
+	"base64Decode": func(value string) (string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		return string(decoded), err
+	},
+	"toJson": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"indent": func(spaces int, value string) string {
+		padding := strings.Repeat(" ", spaces)
+		return padding + strings.ReplaceAll(value, "\n", "\n"+padding)
+	},
+	"nindent": func(spaces int, value string) string {
+		padding := strings.Repeat(" ", spaces)
+		return "\n" + padding + strings.ReplaceAll(value, "\n", "\n"+padding)
+	},
+	"default": func(fallback, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+	"quote": func(value string) string {
+		return fmt.Sprintf("%q", value)
+	},
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// renderTemplates renders each of the opsecret's templates against the 1Password section, returning the output by key
+func (o operator) renderTemplates(opsecret *crds.OpSecret, section onepassword.Section) (map[string]string, error) {
+	rendered := make(map[string]string)
+	if len(opsecret.Spec.Secret.Templates) < 1 {
+		return rendered, nil
+	}
+
+	data := templateData{
+		Values: make(map[string]string),
+		Files:  make(map[string]string),
+	}
+	for key, value := range section.Values {
+		data.Values[key] = value.Value
+	}
+	templates := make(map[string]*template.Template)
+	referenced, allFiles := make(map[string]bool), false
+	for key, text := range opsecret.Spec.Secret.Templates {
+		tmpl, err := parseTemplate(key, text)
+		if err != nil {
+			return nil, err
+		}
+		templates[key] = tmpl
+		// Templates defined within the template are walked too, as they can read files when they are invoked
+		for _, defined := range tmpl.Templates() {
+			allFiles = referencedFiles(defined.Tree.Root, referenced) || allFiles
+		}
+	}
+
+	// Only the files the templates read are fetched, so a file which cannot be fetched only fails the templates using it
+	for name, file := range section.Files {
+		if !allFiles && !referenced[name] {
+			continue
+		}
+		fileContent, err := o.client.FileContent(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s : %w", errFileFetchFailed, file.Name, err)
+		}
+		data.Files[name] = string(fileContent)
+	}
+
+	for key, tmpl := range templates {
+		output := &bytes.Buffer{}
+		if err := tmpl.Execute(output, data); err != nil {
+			return nil, fmt.Errorf("could not render template for key %s : %w", key, err)
+		}
+		rendered[key] = output.String()
+	}
+	return rendered, nil
+}
+
+// referencedFiles adds the name of each file a template reads, as .Files.name, $.Files.name or index .Files "name", to
+// names. It returns true if the template may read files it does not name, so every file is needed. That is any use of
+// .Files itself, such as ranging over it, and any use of the whole data or of a variable, which may hold the files
+func referencedFiles(node parse.Node, names map[string]bool) bool {
+	filesField := func(arg parse.Node) ([]string, bool) {
+		switch field := arg.(type) {
+		case *parse.FieldNode:
+			if len(field.Ident) > 0 && field.Ident[0] == "Files" {
+				return field.Ident[1:], true
+			}
+		case *parse.VariableNode:
+			if len(field.Ident) > 1 && field.Ident[0] == "$" && field.Ident[1] == "Files" {
+				return field.Ident[2:], true
+			}
+		}
+		return nil, false
+	}
+
+	all := false
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			all = referencedFiles(child, names) || all
+		}
+	case *parse.ActionNode:
+		all = referencedFiles(n.Pipe, names)
+	case *parse.IfNode:
+		all = referencedFiles(&n.BranchNode, names)
+	case *parse.RangeNode:
+		all = referencedFiles(&n.BranchNode, names)
+	case *parse.WithNode:
+		all = referencedFiles(&n.BranchNode, names)
+	case *parse.BranchNode:
+		all = referencedFiles(n.Pipe, names)
+		all = referencedFiles(n.List, names) || all
+		all = referencedFiles(n.ElseList, names) || all
+	case *parse.TemplateNode:
+		all = referencedFiles(n.Pipe, names)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, command := range n.Cmds {
+			all = referencedFiles(command, names) || all
+		}
+	case *parse.CommandNode:
+		args := n.Args
+		if len(args) > 2 {
+			identifier, isIdentifier := args[0].(*parse.IdentifierNode)
+			path, isFiles := filesField(args[1])
+			name, isString := args[2].(*parse.StringNode)
+			if isIdentifier && identifier.Ident == "index" && isFiles && len(path) == 0 && isString {
+				names[name.Text] = true
+				args = args[3:]
+			}
+		}
+		for _, arg := range args {
+			if path, ok := filesField(arg); ok {
+				if len(path) == 0 {
+					return true
+				}
+				names[path[0]] = true
+				continue
+			}
+			all = referencedFiles(arg, names) || all
+		}
+	case *parse.ChainNode:
+		all = referencedFiles(n.Node, names)
+	case *parse.DotNode, *parse.VariableNode:
+		// Variables naming a file are matched by filesField before reaching here
+		return true
+	}
+	return all
+}
+
+// parseTemplate parses the template for a secret key with the helpers available to every template
+func parseTemplate(key, text string) (*template.Template, error) {
+	tmpl, err := template.New(key).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template for key %s : %w", key, err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,94 @@
+package operator
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const tlsCAKey = "ca.crt"
+
+// getTLSSecret builds a kubernetes.io/tls secret from the tls.crt, tls.key and optional ca.crt key mappings of the first
+// source, refusing certificates or keys which do not parse or do not match
+func (o operator) getTLSSecret(opsecret *crds.OpSecret, sources []source) (*corev1.Secret, error) {
+	if opsecret.Spec.Secret.SecretType != "tls" {
+		return nil, errors.New("wrong secret type: " + opsecret.Spec.Secret.SecretType)
+	}
+
+	data, err := o.getMappedKeys(sources[0].keys, sources[0].section)
+	if err != nil {
+		return nil, err
+	}
+	for key := range data {
+		if key != corev1.TLSCertKey && key != corev1.TLSPrivateKeyKey && key != tlsCAKey {
+			return nil, fmt.Errorf("unsupported key for tls secret: %s", key)
+		}
+	}
+
+	cert, ok := data[corev1.TLSCertKey]
+	if !ok {
+		return nil, fmt.Errorf("no key mapped to %s", corev1.TLSCertKey)
+	}
+	key, ok := data[corev1.TLSPrivateKeyKey]
+	if !ok {
+		return nil, fmt.Errorf("no key mapped to %s", corev1.TLSPrivateKeyKey)
+	}
+	if _, err = parseCertificates([]byte(cert)); err != nil {
+		return nil, fmt.Errorf("invalid %s : %w", corev1.TLSCertKey, err)
+	}
+
+	secretData := map[string][]byte{}
+	if ca, ok := data[tlsCAKey]; ok {
+		if _, err = parseCertificates([]byte(ca)); err != nil {
+			return nil, fmt.Errorf("invalid %s : %w", tlsCAKey, err)
+		}
+		secretData[tlsCAKey] = []byte(ca)
+		if opsecret.Spec.Secret.FullChain {
+			cert = string(bytes.TrimRight([]byte(cert), "\n")) + "\n" + ca
+		}
+	}
+
+	if _, err = tls.X509KeyPair([]byte(cert), []byte(key)); err != nil {
+		return nil, fmt.Errorf("certificate and key do not form a valid pair : %w", err)
+	}
+	secretData[corev1.TLSCertKey] = []byte(cert)
+	secretData[corev1.TLSPrivateKeyKey] = []byte(key)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName(opsecret),
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: secretData,
+	}, nil
+}
+
+// parseCertificates parses every PEM encoded certificate, failing if there are none or any are invalid
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certificates := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block: %s", block.Type)
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) < 1 {
+		return nil, errors.New("no PEM encoded certificates found")
+	}
+	return certificates, nil
+}
@@ -0,0 +1,62 @@
+package operator
+
+import (
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// typedSecret describes a standard Kubernetes secret type built from key mappings
+type typedSecret struct {
+	secretType corev1.SecretType
+	// defaults are used when the opsecret has no key mappings, and match the fields 1Password creates for the item type
+	defaults []crds.KeyMapping
+	required []string
+}
+
+var typedSecrets = map[string]typedSecret{
+	"ssh": {
+		secretType: corev1.SecretTypeSSHAuth,
+		defaults:   []crds.KeyMapping{{From: "private key", To: corev1.SSHAuthPrivateKey}},
+		required:   []string{corev1.SSHAuthPrivateKey},
+	},
+	"basic-auth": {
+		secretType: corev1.SecretTypeBasicAuth,
+		defaults: []crds.KeyMapping{
+			{From: "username", To: corev1.BasicAuthUsernameKey},
+			{From: "password", To: corev1.BasicAuthPasswordKey},
+		},
+		required: []string{corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey},
+	},
+}
+
+// getTypedSecret builds an ssh or basic-auth secret from the first source
+func (o operator) getTypedSecret(opsecret *crds.OpSecret, sources []source) (*corev1.Secret, error) {
+	typed, ok := typedSecrets[opsecret.Spec.Secret.SecretType]
+	if !ok {
+		return nil, fmt.Errorf("wrong secret type: %s", opsecret.Spec.Secret.SecretType)
+	}
+
+	keys := sources[0].keys
+	if len(keys) < 1 {
+		keys = typed.defaults
+	}
+	data, err := o.getMappedKeys(keys, sources[0].section)
+	if err != nil {
+		return nil, err
+	}
+	for _, required := range typed.required {
+		if data[required] == "" {
+			return nil, fmt.Errorf("%s secrets require a value for %s", opsecret.Spec.Secret.SecretType, required)
+		}
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName(opsecret),
+		},
+		Type:       typed.secretType,
+		StringData: data,
+	}, nil
+}
@@ -0,0 +1,202 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	"github.com/driscollco-cluster/operator-1password/internal/conf"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"path"
+)
+
+var errInvalidSpec = errors.New("invalid opsecret")
+
+// Validate checks an opsecret for mistakes which would stop it ever being reconciled. The reconciler and the
+// validating webhook both use it, so an opsecret is rejected for the same reasons by either
+func Validate(opsecret *crds.OpSecret) error {
+	return toError(validateSpec(opsecret))
+}
+
+// ValidateNew checks an opsecret being created, or whose refresh interval is being changed. As well as the checks made
+// by Validate, it rejects a refresh interval below the minimum, which the reconciler raises to the minimum rather than
+// failing existing opsecrets over
+func ValidateNew(opsecret *crds.OpSecret) error {
+	problems := validateSpec(opsecret)
+	refreshSeconds := opsecret.Spec.Secret.RefreshSeconds
+	minimum := conf.Config.Secrets.Refresh.MinIntervalSeconds
+	if refreshSeconds < 0 || (refreshSeconds > 0 && refreshSeconds < minimum) {
+		problems = append(problems, field.Invalid(field.NewPath("spec", "secret", "refresh-seconds"), refreshSeconds,
+			fmt.Sprintf("must be at least %d seconds, or unset to use the minimum", minimum)))
+	}
+	return toError(problems)
+}
+
+func toError(problems field.ErrorList) error {
+	if len(problems) < 1 {
+		return nil
+	}
+	return fmt.Errorf("%w : %s", errInvalidSpec, problems.ToAggregate().Error())
+}
+
+func validateSpec(opsecret *crds.OpSecret) field.ErrorList {
+	problems := validateSources(opsecret, field.NewPath("spec"))
+	return append(problems, validateSecret(opsecret, field.NewPath("spec", "secret"))...)
+}
+
+func validateSources(opsecret *crds.OpSecret, specPath *field.Path) field.ErrorList {
+	problems := field.ErrorList{}
+	source := opsecret.Spec.Source
+	if source.Vault == "" && source.Item == "" && len(opsecret.Spec.Sources) < 1 {
+		problems = append(problems, field.Required(specPath.Child("source"), "a source or sources must be set"))
+	}
+	if source.Vault != "" || source.Item != "" {
+		problems = append(problems, validateSource(source, specPath.Child("source"))...)
+	}
+	for i, additional := range opsecret.Spec.Sources {
+		problems = append(problems, validateSource(additional.SourceConfig, specPath.Child("sources").Index(i))...)
+	}
+	// The other types are built from a single source, so would silently ignore the rest
+	if secretType := opsecret.Spec.Secret.SecretType; len(opsecret.Spec.Sources) > 0 && secretType != "" && secretType != "basic" {
+		problems = append(problems, field.Forbidden(specPath.Child("sources"), "only basic secrets can have sources, use source"))
+	}
+	return problems
+}
+
+func validateSource(source crds.SourceConfig, sourcePath *field.Path) field.ErrorList {
+	problems := field.ErrorList{}
+	if source.Vault == "" {
+		problems = append(problems, field.Required(sourcePath.Child("vault"), "the 1Password vault must be set"))
+	}
+	if source.Item == "" {
+		problems = append(problems, field.Required(sourcePath.Child("item"), "the 1Password item must be set"))
+	}
+	return problems
+}
+
+func validateSecret(opsecret *crds.OpSecret, secretPath *field.Path) field.ErrorList {
+	problems := field.ErrorList{}
+	secret := opsecret.Spec.Secret
+
+	if secret.Name != "" {
+		for _, message := range validation.IsDNS1123Subdomain(secret.Name) {
+			problems = append(problems, field.Invalid(secretPath.Child("name"), secret.Name, message))
+		}
+	}
+	for i, namespace := range secret.Namespaces {
+		for _, message := range validation.IsDNS1123Label(namespace) {
+			problems = append(problems, field.Invalid(secretPath.Child("namespaces").Index(i), namespace, message))
+		}
+	}
+	if secret.NamespaceSelector != nil {
+		problems = append(problems, validateNamespaceSelector(secret.NamespaceSelector, secretPath.Child("namespace-selector"))...)
+	}
+
+	if outputKind(secret.OutputKind) == crds.OutputKindConfigMap && secret.SecretType != "" && secret.SecretType != "basic" {
+		problems = append(problems, field.Invalid(secretPath.Child("output-kind"), secret.OutputKind,
+			"only basic secrets can be written to a ConfigMap"))
+	}
+	problems = append(problems, validatePatterns(secret.Include, secretPath.Child("include"))...)
+	problems = append(problems, validatePatterns(secret.Exclude, secretPath.Child("exclude"))...)
+
+	if secret.SecretType == "docker" {
+		return append(problems, validateDocker(secret, secretPath)...)
+	}
+	return append(problems, validateKeys(opsecret, secretPath)...)
+}
+
+// validateKeys checks the secret keys written by key mappings and templates are valid and are not written twice
+func validateKeys(opsecret *crds.OpSecret, secretPath *field.Path) field.ErrorList {
+	problems := field.ErrorList{}
+	seen := make(map[string]bool)
+	checkKey := func(key string, keyPath *field.Path) {
+		for _, message := range validation.IsConfigMapKey(key) {
+			problems = append(problems, field.Invalid(keyPath, key, message))
+		}
+		if opsecret.Spec.Secret.SecretType == "tls" && key != corev1.TLSCertKey && key != corev1.TLSPrivateKeyKey && key != tlsCAKey {
+			problems = append(problems, field.NotSupported(keyPath, key, []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, tlsCAKey}))
+		}
+		if seen[key] {
+			problems = append(problems, field.Duplicate(keyPath, key))
+		}
+		seen[key] = true
+	}
+
+	for i, key := range opsecret.Spec.Secret.Keys {
+		checkKey(key.To, secretPath.Child("keys").Index(i).Child("to"))
+	}
+	for key, text := range opsecret.Spec.Secret.Templates {
+		templatePath := secretPath.Child("templates").Key(key)
+		for _, message := range validation.IsConfigMapKey(key) {
+			problems = append(problems, field.Invalid(templatePath, key, message))
+		}
+		if _, err := parseTemplate(key, text); err != nil {
+			problems = append(problems, field.Invalid(templatePath, text, err.Error()))
+		}
+		// A template replaces the key mapping with the same name from the primary source, so is not a duplicate of it,
+		// but is of a key from any other source
+		seen[key] = true
+	}
+	for i, additional := range opsecret.Spec.Sources {
+		for j, key := range additional.Keys {
+			checkKey(key.To, field.NewPath("spec", "sources").Index(i).Child("keys").Index(j).Child("to"))
+		}
+	}
+	return problems
+}
+
+// validateDocker checks a docker secret logs in to at least one registry, and to each registry only once
+func validateDocker(secret crds.SecretConfig, secretPath *field.Path) field.ErrorList {
+	problems := field.ErrorList{}
+	if len(secret.Registries) < 1 && len(secret.Keys) < 1 {
+		return append(problems, field.Required(secretPath.Child("registries"), "docker secrets need registries or keys"))
+	}
+
+	seen := make(map[string]bool)
+	if len(secret.Registries) < 1 {
+		// Each key mapping is a registry
+		for i, key := range secret.Keys {
+			if seen[key.To] {
+				problems = append(problems, field.Duplicate(secretPath.Child("keys").Index(i).Child("to"), key.To))
+			}
+			seen[key.To] = true
+		}
+	}
+	for i, registry := range secret.Registries {
+		registryPath := secretPath.Child("registries").Index(i)
+		if registry.Server == "" {
+			problems = append(problems, field.Required(registryPath.Child("server"), "the registry hostname must be set"))
+		}
+		if seen[registry.Server] {
+			problems = append(problems, field.Duplicate(registryPath.Child("server"), registry.Server))
+		}
+		seen[registry.Server] = true
+		if registry.PasswordFrom == "" {
+			problems = append(problems, field.Required(registryPath.Child("password-from"), "the 1Password key holding the password must be set"))
+		}
+	}
+	return problems
+}
+
+func validateNamespaceSelector(selector *crds.NamespaceSelector, selectorPath *field.Path) field.ErrorList {
+	problems := field.ErrorList{}
+	if selector.Labels != nil {
+		if _, err := metav1.LabelSelectorAsSelector(selector.Labels); err != nil {
+			problems = append(problems, field.Invalid(selectorPath.Child("labels"), selector.Labels, err.Error()))
+		}
+	}
+	return append(problems, validatePatterns(selector.Names, selectorPath.Child("names"))...)
+}
+
+// validatePatterns checks each glob pattern can be matched against
+func validatePatterns(patterns []string, patternsPath *field.Path) field.ErrorList {
+	problems := field.ErrorList{}
+	for i, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, field.Invalid(patternsPath.Index(i), pattern, err.Error()))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,55 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/driscollco-cluster/operator-1password/internal/crds"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// WebhookPath is the path the validating webhook is served on
+const WebhookPath = "/validate-opsecrets"
+
+// NewWebhook returns the validating webhook for OpSecrets and ClusterOpSecrets, which rejects any created failing
+// ValidateNew, and any whose spec is changed to fail it
+func NewWebhook() *admission.Webhook {
+	return &admission.Webhook{Handler: admission.HandlerFunc(validateAdmission)}
+}
+
+func validateAdmission(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	// A ClusterOpSecret has the same fields as an OpSecret, so both are read as one
+	opsecret := &crds.OpSecret{}
+	if err := json.Unmarshal(req.Object.Raw, opsecret); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if opsecret.Namespace == "" {
+		opsecret.Namespace = req.Namespace
+	}
+
+	validate := ValidateNew
+	if req.Operation == admissionv1.Update {
+		previous := &crds.OpSecret{}
+		if err := json.Unmarshal(req.OldObject.Raw, previous); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		// The operator's own finalizer and annotation changes are always allowed, so an opsecret which was admitted
+		// before the rules changed, or while the webhook was down, can still be reconciled and deleted
+		if !opsecret.DeletionTimestamp.IsZero() || equality.Semantic.DeepEqual(previous.Spec, opsecret.Spec) {
+			return admission.Allowed("")
+		}
+		// A refresh interval below a minimum which has since been raised is only rejected once it is changed
+		if previous.Spec.Secret.RefreshSeconds == opsecret.Spec.Secret.RefreshSeconds {
+			validate = Validate
+		}
+	}
+	if err := validate(opsecret); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}